@@ -0,0 +1,42 @@
+package win
+
+import (
+	"net/http"
+)
+
+// 握手认证，返回连接的身份信息（用户ID、角色、claims等），
+// 返回错误时拒绝连接，使用Reject可以指定HTTP状态码和响应内容，其他错误返回401
+type AuthFunc func(r *http.Request) (identity interface{}, err error)
+
+// 拒绝握手时返回给客户端的HTTP响应
+type AuthError struct {
+	Status int
+	Body   string
+}
+
+func Reject(status int, body string) *AuthError {
+	return &AuthError{Status: status, Body: body}
+}
+
+func (e *AuthError) Error() string {
+	return "win: handshake rejected: " + e.Body
+}
+
+// 执行认证，失败时已写入HTTP响应
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (interface{}, bool) {
+	if s.authHandler == nil {
+		return nil, true
+	}
+	identity, err := s.authHandler(r)
+	if err == nil {
+		return identity, true
+	}
+
+	status, body := http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized)
+	if e, ok := err.(*AuthError); ok {
+		status, body = e.Status, e.Body
+	}
+	s.Logger().Log(LevelDebug, "handshake rejected", F("status", status), F("remote_addr", r.RemoteAddr), fieldError(err))
+	http.Error(w, body, status)
+	return nil, false
+}
@@ -0,0 +1,262 @@
+package win
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 服务端收到的一批请求，所有需要回复的请求都回复后合并成一个数组发送
+type batch struct {
+	conn      *Conn
+	mu        sync.Mutex
+	pending   int
+	sealed    bool
+	responses [][]byte
+}
+
+// 批量请求中的一个请求，保证只计入一次回复
+type batchSlot struct {
+	batch *batch
+	once  sync.Once
+}
+
+func newBatch(conn *Conn) *batch {
+	return &batch{conn: conn}
+}
+
+// 增加一个需要回复的请求
+func (b *batch) slot() *batchSlot {
+	b.mu.Lock()
+	b.pending++
+	b.mu.Unlock()
+	return &batchSlot{batch: b}
+}
+
+// 不对应任何请求的响应（如解析错误）
+func (b *batch) add(data []byte) {
+	b.mu.Lock()
+	b.responses = append(b.responses, data)
+	b.mu.Unlock()
+}
+
+// 所有请求都已分发，之后最后一个回复到达时发送
+func (b *batch) seal() {
+	b.mu.Lock()
+	b.sealed = true
+	b.mu.Unlock()
+	b.flush()
+}
+
+func (b *batch) flush() {
+	b.mu.Lock()
+	if !b.sealed || b.pending > 0 || b.responses == nil {
+		b.mu.Unlock()
+		return
+	}
+	responses := b.responses
+	b.responses = nil
+	b.mu.Unlock()
+
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	buf.Write(bytes.Join(responses, []byte{','}))
+	buf.WriteByte(']')
+	if err := b.conn.enqueue(buf.Bytes()); err != nil {
+		b.conn.logger().Log(LevelWarn, "send batch response failed", fieldConn(b.conn.id), fieldError(err))
+	}
+}
+
+func (s *batchSlot) reply(resp *Response) error {
+	data, err := s.batch.conn.format.encodeResponse(resp)
+	if err != nil {
+		// 已经标记为回复过，这里不回复的话批量响应会一直等待
+		s.batch.conn.logger().Log(LevelWarn, "encode batch response failed", fieldConn(s.batch.conn.id), fieldRequest(resp.ID), fieldError(err))
+		errResp := Response{
+			Method: resp.Method,
+			ID:     resp.ID,
+			rawID:  resp.rawID,
+			Error:  builtinError(CodeInternalError, "internal error"),
+		}
+		if data, e := s.batch.conn.format.encodeResponse(&errResp); e == nil {
+			s.fill(data)
+		} else {
+			s.skip()
+		}
+		return err
+	}
+	if !s.fill(data) {
+		return errors.New("win: request in batch already replied")
+	}
+	return nil
+}
+
+// 放入回复，已经回复过或跳过时返回false
+func (s *batchSlot) fill(data []byte) bool {
+	filled := false
+	s.once.Do(func() {
+		filled = true
+		s.batch.mu.Lock()
+		s.batch.responses = append(s.batch.responses, data)
+		s.batch.pending--
+		s.batch.mu.Unlock()
+		s.batch.flush()
+	})
+	return filled
+}
+
+// 不回复的请求（如已取消或处理函数没有回复）不计入等待
+func (s *batchSlot) skip() {
+	s.once.Do(func() {
+		s.batch.mu.Lock()
+		s.batch.pending--
+		s.batch.mu.Unlock()
+		s.batch.flush()
+	})
+}
+
+// 是否为JSON数组
+func isBatch(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	return len(data) > 0 && data[0] == '['
+}
+
+// 拆分批量消息，空数组是无效请求
+func splitBatch(f messageFormat, data []byte) ([]json.RawMessage, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, f.parseError(err)
+	}
+	if len(items) == 0 {
+		return nil, f.invalidRequest("empty batch")
+	}
+	return items, nil
+}
+
+// 客户端的批量请求，所有请求在一个消息中发送
+type Batch struct {
+	client *Client
+	reqs   []*Request
+	calls  []*BatchCall
+	err    error
+}
+
+// 批量请求中的一次调用，Send返回后Error为这次调用的错误
+type BatchCall struct {
+	Method string
+	Reply  interface{}
+	Error  error
+	call   *call
+}
+
+// 创建一个批量请求
+func (c *Client) Batch() *Batch {
+	return &Batch{client: c}
+}
+
+// 添加一个需要返回的请求，结果解析到reply
+func (b *Batch) Call(method string, params, reply interface{}) *BatchCall {
+	bc := &BatchCall{Method: method, Reply: reply}
+	req := &Request{Method: method}
+	if err := req.SetParams(params); err != nil && b.err == nil {
+		b.err = err
+	}
+	b.reqs = append(b.reqs, req)
+	b.calls = append(b.calls, bc)
+	return bc
+}
+
+// 添加一个不需要返回的通知
+func (b *Batch) Notify(method string, params interface{}) {
+	req := &Request{Method: method}
+	if err := req.SetParams(params); err != nil && b.err == nil {
+		b.err = err
+	}
+	b.reqs = append(b.reqs, req)
+	b.calls = append(b.calls, nil)
+}
+
+// 发送批量请求，阻塞到所有调用返回或超时。
+// 返回值为发送失败或超时的错误，每次调用自己的错误在BatchCall.Error中
+func (b *Batch) Send(opts ...*CallOpt) error {
+	if b.err != nil {
+		return b.err
+	}
+	if len(b.reqs) == 0 {
+		return errors.New("win: empty batch")
+	}
+	var opt *CallOpt
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt != nil && len(opt.Headers) > 0 {
+		for _, req := range b.reqs {
+			req.SetHeaders(opt.Headers)
+		}
+	}
+
+	c := b.client
+	waits := make([]bool, len(b.reqs))
+	for i, bc := range b.calls {
+		waits[i] = bc != nil
+	}
+	calls, err := c.sendBatch(b.reqs, waits, opt)
+	if err != nil {
+		return err
+	}
+
+	timeout := c.timeout
+	if opt != nil && opt.Timeout > 0 {
+		timeout = opt.Timeout
+	}
+	t := time.NewTimer(time.Millisecond * time.Duration(timeout))
+	defer t.Stop()
+
+	var timeoutErr error
+	for i, bc := range b.calls {
+		if bc == nil {
+			continue
+		}
+		bc.call = calls[i]
+		if timeoutErr == nil {
+			select {
+			case err, ok := <-bc.call.done:
+				bc.finish(err, ok)
+				continue
+			case <-t.C:
+				timeoutErr = fmt.Errorf("batch timeout %d ms", timeout)
+			}
+		}
+		// 超时后只收集已经返回的结果
+		select {
+		case err, ok := <-bc.call.done:
+			bc.finish(err, ok)
+		default:
+			c.cancel(bc.call)
+			bc.Error = timeoutErr
+		}
+	}
+	return timeoutErr
+}
+
+func (bc *BatchCall) finish(err error, ok bool) {
+	if !ok {
+		bc.Error = errors.New("conn has closed")
+		return
+	}
+	if err != nil {
+		bc.Error = err
+		return
+	}
+	if bc.Reply == nil {
+		return
+	}
+	result := bc.call.response.Result
+	if result == nil {
+		result = &jsonNull
+	}
+	bc.Error = json.Unmarshal(*result, bc.Reply)
+}
@@ -0,0 +1,214 @@
+package win
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 处理服务端发起的请求，返回值作为结果回复，返回*Error时保留错误码，
+// ctx在服务端取消请求或连接关闭时取消
+type ClientCallHandler func(ctx context.Context, req Request) (interface{}, error)
+
+// 向客户端发起请求，阻塞到客户端回复、超时或ctx取消，超时和取消时通知客户端取消请求。
+// 连接关闭或Shutdown停止读取后返回ErrConnClosed
+func (c *Conn) Call(ctx context.Context, method string, params, reply interface{}) error {
+	req := &Request{
+		Method: method,
+	}
+	if err := req.SetParams(params); err != nil {
+		return err
+	}
+
+	c.callMu.Lock()
+	// 停止读取后收不到回复
+	if c.isDraining() {
+		c.callMu.Unlock()
+		return ErrConnClosed
+	}
+	c.callSeq++
+	req.ID = c.callSeq
+	cc := &call{request: req, seq: req.ID, done: make(chan error, 1)}
+	c.calls[req.ID] = cc
+	c.callMu.Unlock()
+
+	data, err := c.format.encodeRequest(req)
+	if err == nil {
+		err = c.enqueue(data)
+	}
+	if err != nil {
+		c.forgetCall(cc)
+		return err
+	}
+
+	var timeout <-chan time.Time
+	if d := c.Server.config.callTimeout; d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case err := <-cc.done:
+		if err != nil {
+			return err
+		}
+		if reply == nil {
+			return nil
+		}
+		result := cc.response.Result
+		if result == nil {
+			result = &jsonNull
+		}
+		return json.Unmarshal(*result, reply)
+	case <-timeout:
+		c.cancelCall(cc)
+		return fmt.Errorf("win: call [%s] timeout %v", method, c.Server.config.callTimeout)
+	case <-ctx.Done():
+		c.cancelCall(cc)
+		return ctx.Err()
+	case <-c.exitChan:
+		c.forgetCall(cc)
+		return ErrConnClosed
+	}
+}
+
+// 客户端对Conn.Call的回复
+func (c *Conn) handleResponse(resp *Response) {
+	c.callMu.Lock()
+	cc := c.calls[resp.ID]
+	delete(c.calls, resp.ID)
+	c.callMu.Unlock()
+
+	if cc == nil {
+		c.logger().Log(LevelDebug, "ignoring response with no corresponding request", fieldConn(c.id), fieldRequest(resp.ID))
+		return
+	}
+	cc.response = resp
+	if resp.Error != nil {
+		cc.done <- resp.Error
+	} else {
+		cc.done <- nil
+	}
+}
+
+// 让等待回复的Call返回err
+func (c *Conn) failCalls(err error) {
+	c.callMu.Lock()
+	calls := c.calls
+	c.calls = make(map[int64]*call)
+	c.callMu.Unlock()
+	for _, cc := range calls {
+		cc.done <- err
+	}
+}
+
+func (c *Conn) forgetCall(cc *call) {
+	c.callMu.Lock()
+	if c.calls[cc.request.ID] == cc {
+		delete(c.calls, cc.request.ID)
+	}
+	c.callMu.Unlock()
+}
+
+// 不再等待回复，并通知客户端取消
+func (c *Conn) cancelCall(cc *call) {
+	c.forgetCall(cc)
+	resp := Response{Method: CancelMethod}
+	resp.setResult(map[string]int64{"id": cc.request.ID})
+	if err := c.SendMessage(resp); err != nil {
+		c.logger().Log(LevelDebug, "send cancel failed", fieldConn(c.id), fieldRequest(cc.request.ID), fieldError(err))
+	}
+}
+
+// 注册处理服务端请求的函数
+func (c *Client) AddCallHandler(name string, h ClientCallHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.callHandlers == nil {
+		c.callHandlers = make(map[string]ClientCallHandler)
+	}
+	if _, ok := c.callHandlers[name]; ok {
+		panic("Repeated handler name: " + name)
+	}
+	c.callHandlers[name] = h
+	c.logger.Log(LevelDebug, "add call handler", fieldMethod(name))
+}
+
+// 在新的goroutine中处理服务端请求，避免阻塞读goroutine
+func (c *Client) handleRequest(req *Request) {
+	key := requestKey(req.ID, req.rawID)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c.mu.Lock()
+	h, ok := c.callHandlers[req.Method]
+	if ok {
+		c.serving[key] = cancel
+	}
+	c.mu.Unlock()
+
+	resp := Response{Method: req.Method, ID: req.ID, rawID: req.rawID}
+	if !ok {
+		cancel()
+		c.logger.Log(LevelDebug, "call handler not found", fieldMethod(req.Method), fieldRequest(req.ID))
+		resp.Error = builtinError(CodeNotFound, "not found")
+		c.sendResponse(&resp)
+		return
+	}
+
+	go func() {
+		result, err := h(ctx, *req)
+		c.mu.Lock()
+		_, ok := c.serving[key]
+		delete(c.serving, key)
+		c.mu.Unlock()
+		cancel()
+		// 已被服务端取消的请求不再回复
+		if !ok {
+			return
+		}
+
+		var e *Error
+		if err == nil {
+			err = resp.setResult(result)
+		}
+		if errors.As(err, &e) {
+			resp.Error = e
+		} else if err != nil {
+			resp.Error = builtinError(CodeInternalError, err.Error())
+		}
+		c.sendResponse(&resp)
+	}()
+}
+
+// 服务端取消了Conn.Call
+func (c *Client) handleCancel(resp Response) {
+	var params struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if resp.Result == nil || json.Unmarshal(*resp.Result, &params) != nil || len(params.ID) == 0 {
+		c.logger.Log(LevelWarn, "invalid cancel message")
+		return
+	}
+	key := string(params.ID)
+	c.mu.Lock()
+	cancel, ok := c.serving[key]
+	delete(c.serving, key)
+	c.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+func (c *Client) sendResponse(resp *Response) {
+	data, err := c.format.encodeResponse(resp)
+	if err == nil {
+		c.sending.Lock()
+		err = c.write(data)
+		c.sending.Unlock()
+	}
+	if err != nil {
+		c.logger.Log(LevelWarn, "send response failed", fieldMethod(resp.Method), fieldRequest(resp.ID), fieldError(err))
+	}
+}
@@ -0,0 +1,117 @@
+package win
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// 保留的取消方法，参数为{"id": 要取消的请求id}
+const CancelMethod = "$/cancel"
+
+var ErrRequestCanceled = errors.New("win: request canceled by client")
+
+// 一次请求的状态，Context按值传递，各副本通过指针共享
+type requestState struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	key      string
+	canceled int32
+
+	// 中间件链和请求内的键值，见chain.go
+	mu           sync.Mutex
+	frames       []*chainFrame
+	aborted      bool
+	keys         map[string]interface{}
+	interceptors []ResponseInterceptor
+	replied      bool
+	async        bool
+
+	// 处理函数和异步回复各持有一个引用，都释放后请求结束，见reply.go
+	refs       int
+	done       func()
+	finishOnce sync.Once
+}
+
+// 请求id转换为登记用的key，JSON-RPC的数字id和框架的int64 id得到相同的key
+func requestKey(id int64, rawID json.RawMessage) string {
+	if rawID != nil {
+		return string(bytes.TrimSpace(rawID))
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// 创建请求的context，需要回复的请求登记后才能被取消
+func (c *Conn) newRequestState(request *Request) *requestState {
+	st := requestState{refs: 1}
+	if timeout := c.Server.config.handlerTimeout; timeout > 0 {
+		st.ctx, st.cancel = context.WithTimeout(c.ctx, timeout)
+	} else {
+		st.ctx, st.cancel = context.WithCancel(c.ctx)
+	}
+	if request.IsNotification() {
+		return &st
+	}
+	st.key = requestKey(request.ID, request.rawID)
+	c.reqMu.Lock()
+	c.requests[st.key] = &st
+	c.reqMu.Unlock()
+	return &st
+}
+
+// 处理函数返回后释放请求的context
+func (c *Conn) releaseRequest(st *requestState) {
+	if st.cancel == nil {
+		return
+	}
+	st.cancel()
+	if st.key == "" {
+		return
+	}
+	c.reqMu.Lock()
+	if c.requests[st.key] == st {
+		delete(c.requests, st.key)
+	}
+	c.reqMu.Unlock()
+}
+
+// 取消处理中的请求，之后该请求的回复不再发送
+func (c *Conn) cancelRequest(key string) bool {
+	c.reqMu.Lock()
+	st, ok := c.requests[key]
+	if ok {
+		delete(c.requests, key)
+	}
+	c.reqMu.Unlock()
+	if !ok {
+		return false
+	}
+	atomic.StoreInt32(&st.canceled, 1)
+	st.cancel()
+	return true
+}
+
+// 在读goroutine中直接处理取消消息，不经过路由和中间件
+func (c *Conn) handleCancel(ctx Context) {
+	var params struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := ctx.BindJson(&params); err != nil || len(params.ID) == 0 {
+		ctx.replyError(builtinError(CodeInvalidParams, "invalid params", "missing id"))
+		return
+	}
+	key := string(bytes.TrimSpace(params.ID))
+	ok := c.cancelRequest(key)
+	c.logger().Log(LevelDebug, "cancel request", fieldConn(c.id), F("request", key), F("found", ok))
+	if !ctx.Request.IsNotification() {
+		ctx.Reply(ok)
+	}
+}
+
+func (st *requestState) isCanceled() bool {
+	return st != nil && atomic.LoadInt32(&st.canceled) == 1
+}
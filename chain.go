@@ -0,0 +1,126 @@
+package win
+
+import "errors"
+
+// 中间件链的一层，next为这一层之后的处理函数
+type chainFrame struct {
+	next   HandlerFunc
+	called bool
+}
+
+// 把gin风格的中间件转换为MiddlewareFunc：在h中调用ctx.Next()执行后面的处理函数，
+// 没有调用时h返回后自动执行，调用ctx.Abort()后不再执行
+func Middleware(h HandlerFunc) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx Context) {
+			st := ctx.requestState()
+			ctx.state = st
+			frame := &chainFrame{next: next}
+			st.mu.Lock()
+			st.frames = append(st.frames, frame)
+			st.mu.Unlock()
+
+			h(ctx)
+
+			st.mu.Lock()
+			st.frames = st.frames[:len(st.frames)-1]
+			st.mu.Unlock()
+			ctx.runNext(frame)
+		}
+	}
+}
+
+// 请求状态，不是由连接分发的Context（如NewContext创建的）第一次使用时创建
+func (c *Context) requestState() *requestState {
+	if c.state == nil {
+		c.state = &requestState{}
+	}
+	return c.state
+}
+
+// 在中间件中执行后面的处理函数，返回时后面的处理函数都已执行完
+func (c *Context) Next() {
+	st := c.state
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	if len(st.frames) == 0 {
+		st.mu.Unlock()
+		return
+	}
+	frame := st.frames[len(st.frames)-1]
+	st.mu.Unlock()
+	c.runNext(frame)
+}
+
+func (c *Context) runNext(frame *chainFrame) {
+	st := c.state
+	st.mu.Lock()
+	if frame.called || st.aborted {
+		st.mu.Unlock()
+		return
+	}
+	frame.called = true
+	st.mu.Unlock()
+	frame.next(*c)
+}
+
+// 不再执行后面的中间件和处理函数，当前函数剩下的代码照常执行
+func (c *Context) Abort() {
+	st := c.requestState()
+	st.mu.Lock()
+	st.aborted = true
+	st.mu.Unlock()
+}
+
+// Abort并把err交给ErrorHandler回复
+func (c *Context) AbortWithError(err error) {
+	c.Abort()
+	c.handleError(err)
+}
+
+// 是否已Abort
+func (c *Context) IsAborted() bool {
+	if c.state == nil {
+		return false
+	}
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	return c.state.aborted
+}
+
+// 取连接上保存的值，连接内所有请求共享
+func (c *Context) Get(key string) (interface{}, error) {
+	if val, ok := c.Conn.Get(key); ok {
+		return val, nil
+	}
+	return nil, errors.New("Not exist key: " + key)
+}
+
+// 在连接上保存值，连接内所有请求共享，只在当前请求内可见的值用SetValue
+func (c *Context) Set(key string, val interface{}) {
+	c.Conn.Set(key, val)
+}
+
+// 取SetValue保存的值
+func (c *Context) Value(key string) (interface{}, bool) {
+	if c.state == nil {
+		return nil, false
+	}
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	val, ok := c.state.keys[key]
+	return val, ok
+}
+
+// 保存只在当前请求内可见的值，用于中间件向后续处理函数传值
+func (c *Context) SetValue(key string, val interface{}) {
+	st := c.requestState()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.keys == nil {
+		st.keys = make(map[string]interface{})
+	}
+	st.keys[key] = val
+}
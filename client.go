@@ -1,13 +1,15 @@
 package win
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
-	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,56 +20,181 @@ type (
 type CallOpt struct {
 	Timeout uint
 	Headers map[string]interface{}
+	// 收到服务端Context.Progress发送的进度时调用，在读goroutine中执行，不能阻塞
+	OnProgress func(p Progress)
+}
+
+// 请求登记前设置回调
+func (o *CallOpt) attach(cc *call) {
+	if o != nil {
+		cc.onProgress = o.OnProgress
+	}
 }
 
 type call struct {
-	request  *Request
-	response *Response
-	seq      int64 // the seq of the Request
-	done     chan error
+	request    *Request
+	response   *Response
+	seq        int64 // the seq of the Request
+	done       chan error
+	stream     *ClientStream
+	onProgress func(p Progress)
 }
 
 type Client struct {
-	conn     *websocket.Conn
-	exitChan chan bool
-	seq      int64
-	pending  map[int64]*call
-	timeout  uint
-	sending  sync.Mutex
-	handlers map[string]ClientHandler
-	mu       sync.Mutex
-}
-
-func Dial(urlStr string, requestHeader http.Header) (*Client, error) {
-	c, _, err := websocket.DefaultDialer.Dial(urlStr, requestHeader)
-	if err != nil {
-		log.Printf("[win-debug]: websocket dial err: %v", err)
-		return nil, err
+	lastSeen     int64 // unix纳秒，atomic访问，放在首位保证64位对齐
+	conn         *websocket.Conn
+	exitChan     chan struct{}
+	closeOnce    sync.Once
+	seq          int64
+	pending      map[int64]*call
+	timeout      uint
+	sending      sync.Mutex
+	handlers     map[string]ClientHandler
+	callHandlers map[string]ClientCallHandler
+	serving      map[string]context.CancelFunc
+	mu           sync.Mutex
+	pingInterval time.Duration
+	pongWait     time.Duration
+	writeWait    time.Duration
+	logger       Logger
+	protocol     Protocol
+	format       messageFormat
+	codec        Codec
+}
+
+type ClientOption func(c *Client)
+
+// 设置消息协议，需要和服务端一致，默认ProtocolWin
+func WithClientProtocol(protocol Protocol) ClientOption {
+	return func(c *Client) {
+		c.protocol = protocol
+	}
+}
+
+// 请求使用的消息编解码，服务端不支持时退回JSON，默认JSON
+func WithClientCodec(codec Codec) ClientOption {
+	return func(c *Client) {
+		if codec == nil {
+			codec = JSONCodec
+		}
+		c.codec = codec
+	}
+}
+
+// 设置客户端日志输出，默认不输出
+func WithClientLogger(logger Logger) ClientOption {
+	return func(c *Client) {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		c.logger = logger
 	}
+}
 
+// 设置客户端心跳，含义同SetHeartbeat，传0关闭对应的功能
+func WithClientHeartbeat(pingInterval, pongWait, writeWait time.Duration) ClientOption {
+	return func(c *Client) {
+		c.pingInterval = pingInterval
+		c.pongWait = pongWait
+		c.writeWait = writeWait
+	}
+}
+
+func Dial(urlStr string, requestHeader http.Header, opts ...ClientOption) (*Client, error) {
 	cli := &Client{
-		conn:     c,
-		exitChan: make(chan bool),
-		pending:  make(map[int64]*call),
-		timeout:  5000,
+		exitChan:     make(chan struct{}),
+		pending:      make(map[int64]*call),
+		serving:      make(map[string]context.CancelFunc),
+		timeout:      5000,
+		pingInterval: 54 * time.Second,
+		pongWait:     60 * time.Second,
+		writeWait:    10 * time.Second,
+		logger:       nopLogger{},
+		codec:        JSONCodec,
+	}
+	for _, opt := range opts {
+		opt(cli)
 	}
+	cli.format = cli.protocol.format()
+
+	dialer := *websocket.DefaultDialer
+	if cli.codec != JSONCodec {
+		dialer.Subprotocols = []string{cli.codec.Name(), JSONCodec.Name()}
+	}
+	c, _, err := dialer.Dial(urlStr, requestHeader)
+	if err != nil {
+		cli.logger.Log(LevelWarn, "websocket dial failed", fieldError(err))
+		return nil, err
+	}
+	cli.conn = c
+	if c.Subprotocol() != cli.codec.Name() {
+		cli.codec = JSONCodec
+	}
+
+	cli.touch()
+	c.SetPongHandler(func(string) error {
+		cli.touch()
+		return nil
+	})
+	c.SetPingHandler(func(data string) error {
+		cli.touch()
+		return replyPong(c, data, cli.writeWait)
+	})
 
 	waitGroup := sync.WaitGroup{}
 	waitGroup.Add(1)
 	go cli.readMessages(&waitGroup)
 	waitGroup.Wait()
+	if cli.pingInterval > 0 {
+		go cli.pingLoop()
+	}
 	return cli, nil
 }
 
+// 连接协商使用的消息编解码
+func (c *Client) Codec() Codec {
+	return c.codec
+}
+
 func (c *Client) Close() {
-	err := c.conn.Close()
-	if err != nil {
-		log.Printf("[win-debug]: client Close err: %v", err)
+	c.closeOnce.Do(func() {
+		close(c.exitChan)
+		err := c.conn.Close()
+		if err != nil {
+			c.logger.Log(LevelDebug, "client close failed", fieldError(err))
+		}
+		c.logger.Log(LevelDebug, "client closed")
+	})
+}
+
+// 最后一次收到服务端数据（消息、ping或pong）的时间
+func (c *Client) LastSeen() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastSeen))
+}
+
+// 记录服务端活跃并延长读超时
+func (c *Client) touch() {
+	atomic.StoreInt64(&c.lastSeen, time.Now().UnixNano())
+	if c.pongWait > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.pongWait))
 	}
-	c.exitChan <- true
-	close(c.exitChan)
+}
 
-	log.Printf("[win-debug]: client closed")
+func (c *Client) pingLoop() {
+	ticker := time.NewTicker(c.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.exitChan:
+			return
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, writeDeadline(c.writeWait)); err != nil {
+				c.logger.Log(LevelDebug, "client ping failed", fieldError(err))
+				c.Close()
+				return
+			}
+		}
+	}
 }
 
 func (c *Client) AddHandler(name string, h ClientHandler) {
@@ -78,48 +205,92 @@ func (c *Client) AddHandler(name string, h ClientHandler) {
 		panic("Repeated handler name: " + name)
 	}
 	c.handlers[name] = h
-	log.Printf("[win-debug]: add handler %s", name)
+	c.logger.Log(LevelDebug, "add handler", fieldMethod(name))
 }
 
 func (c *Client) readMessages(waitGroup *sync.WaitGroup) {
-	log.Printf("[win-debug]: goroutine readMessages runing")
-	defer log.Printf("[win-debug]: goroutine readMessages closed")
 	waitGroup.Done()
 	for {
-		var resp Response
-		err := c.conn.ReadJSON(&resp)
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if !websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("[win-debug]: conn has closed: %v", err)
-				break
+				c.logger.Log(LevelDebug, "conn has closed", fieldError(err))
 			} else {
-				log.Printf("[win-debug]: read message error: %v", err)
-				continue
+				c.logger.Log(LevelWarn, "read message error", fieldError(err))
 			}
-		}
-		c.handleResponse(resp)
-		select {
-		case <-c.exitChan:
 			break
-		default:
+		}
+		c.touch()
+
+		if data, err = c.codec.Decode(data); err != nil {
+			c.logger.Log(LevelWarn, "decode message failed", fieldError(err))
+			continue
+		}
+		if !isBatch(data) {
+			c.handleMessage(data)
+			continue
+		}
+		items, err := splitBatch(c.format, data)
+		if err != nil {
+			c.logger.Log(LevelWarn, "decode batch failed", fieldError(err))
+			continue
+		}
+		for _, item := range items {
+			c.handleMessage(item)
 		}
 	}
 
+	c.Close()
+
 	c.mu.Lock()
-	for _, call := range c.pending {
+	for id, call := range c.pending {
 		call.done <- errors.New("client Close")
 		close(call.done)
+		delete(c.pending, id)
+	}
+	for key, cancel := range c.serving {
+		cancel()
+		delete(c.serving, key)
 	}
 	c.mu.Unlock()
 }
 
+func (c *Client) handleMessage(data []byte) {
+	req, resp, err := c.format.decode(data)
+	if err != nil {
+		c.logger.Log(LevelWarn, "decode message failed", fieldError(err))
+		return
+	}
+	if req != nil && !req.IsNotification() {
+		c.handleRequest(req)
+		return
+	}
+	if req != nil {
+		// JSON-RPC的服务端推送是通知
+		resp = &Response{Method: req.Method, Result: req.Params, Headers: req.Headers}
+	}
+	c.handleResponse(*resp)
+}
+
 func (c *Client) handleResponse(resp Response) {
+	if resp.ID == 0 && resp.Method == StreamMethod {
+		c.handleStreamChunk(resp)
+		return
+	}
+	if resp.ID == 0 && resp.Method == ProgressMethod {
+		c.handleProgress(resp)
+		return
+	}
+	if resp.ID == 0 && resp.Method == CancelMethod {
+		c.handleCancel(resp)
+		return
+	}
 	if resp.ID == 0 {
 		c.mu.Lock()
 		if h, ok := c.handlers[resp.Method]; ok {
 			h(resp)
 		} else {
-			log.Printf("[win-debug] ignoring response %s with no handler", resp.Method)
+			c.logger.Log(LevelDebug, "ignoring response with no handler", fieldMethod(resp.Method))
 		}
 		c.mu.Unlock()
 	} else {
@@ -135,7 +306,7 @@ func (c *Client) handleResponse(resp Response) {
 
 		switch {
 		case call == nil:
-			log.Printf("[win-debug] ignoring response %d with no corresponding request", id)
+			c.logger.Log(LevelDebug, "ignoring response with no corresponding request", fieldRequest(id))
 		case resp.Error != nil:
 			call.done <- resp.Error
 			close(call.done)
@@ -147,43 +318,119 @@ func (c *Client) handleResponse(resp Response) {
 
 }
 
-func (c *Client) sendMessage(request *Request, wait bool) (cc *call, err error) {
+// 流式数据块交给对应请求的ClientStream
+func (c *Client) handleStreamChunk(resp Response) {
+	var chunk struct {
+		ID   int64           `json:"id"`
+		Data json.RawMessage `json:"data"`
+	}
+	if resp.Result == nil || json.Unmarshal(*resp.Result, &chunk) != nil {
+		c.logger.Log(LevelWarn, "invalid stream chunk")
+		return
+	}
+	c.mu.Lock()
+	call := c.pending[chunk.ID]
+	c.mu.Unlock()
+	if call == nil || call.stream == nil {
+		c.logger.Log(LevelDebug, "ignoring stream chunk with no corresponding stream", fieldRequest(chunk.ID))
+		return
+	}
+	if chunk.Data == nil {
+		chunk.Data = jsonNull
+	}
+	call.stream.push(chunk.Data)
+}
+
+func (c *Client) sendMessage(request *Request, wait bool) (*call, error) {
+	calls, err := c.send([]*Request{request}, []bool{wait}, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	return calls[0], nil
+}
+
+// 批量发送，waits[i]为true的请求需要等待返回
+func (c *Client) sendBatch(requests []*Request, waits []bool, opt *CallOpt) ([]*call, error) {
+	return c.send(requests, waits, true, opt.attach)
+}
+
+// setup不为nil时在登记前设置每个需要等待的请求，如接收流式数据块和进度
+func (c *Client) send(requests []*Request, waits []bool, asBatch bool, setup func(cc *call)) (calls []*call, err error) {
 	c.sending.Lock()
 	defer c.sending.Unlock()
 
-	var id int64
-
-	if wait {
+	calls = make([]*call, len(requests))
+	for i, request := range requests {
+		if !waits[i] {
+			continue
+		}
 		c.seq++
-		cc = &call{request: request, seq: c.seq, done: make(chan error, 1)}
+		cc := &call{request: request, seq: c.seq, done: make(chan error, 1)}
+		if setup != nil {
+			setup(cc)
+		}
 		if request.ID == 0 {
 			request.ID = c.seq
 		}
-		id = request.ID
 		c.mu.Lock()
-		c.pending[id] = cc
+		c.pending[request.ID] = cc
 		c.mu.Unlock()
+		calls[i] = cc
 	}
 
 	defer func() {
 		if err != nil {
-			if cc != nil {
-				c.mu.Lock()
-				delete(c.pending, id)
-				c.mu.Unlock()
-			}
+			c.forget(calls...)
 		}
 	}()
 
-	err = c.conn.WriteJSON(request)
-	if err != nil {
+	encoded := make([][]byte, len(requests))
+	for i, request := range requests {
+		if encoded[i], err = c.format.encodeRequest(request); err != nil {
+			return nil, err
+		}
+	}
+	data := encoded[0]
+	if asBatch {
+		data = append(append([]byte{'['}, bytes.Join(encoded, []byte{','})...), ']')
+	}
+
+	if err = c.write(data); err != nil {
 		return nil, err
 	}
-	return cc, nil
+	return calls, nil
+}
+
+// 转换为线路格式后写入连接，调用方需持有sending锁
+func (c *Client) write(data []byte) error {
+	data, err := c.codec.Encode(data)
+	if err != nil {
+		return err
+	}
+	if c.writeWait > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeWait))
+	}
+	return c.conn.WriteMessage(c.codec.MessageType(), data)
+}
+
+// 不再等待这些请求的返回
+func (c *Client) forget(calls ...*call) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cc := range calls {
+		if cc != nil && c.pending[cc.request.ID] == cc {
+			delete(c.pending, cc.request.ID)
+		}
+	}
 }
 
 // 发起请求，阻塞到数据返回或超时
 func (c *Client) Call(method string, params, reply interface{}, opts ...*CallOpt) error {
+	return c.CallContext(context.Background(), method, params, reply, opts...)
+}
+
+// 发起请求，阻塞到数据返回、超时或ctx取消，超时和取消时通知服务端取消请求
+func (c *Client) CallContext(ctx context.Context, method string, params, reply interface{}, opts ...*CallOpt) error {
 	req := Request{
 		Method: method,
 	}
@@ -201,10 +448,11 @@ func (c *Client) Call(method string, params, reply interface{}, opts ...*CallOpt
 		req.SetHeaders(opt.Headers)
 	}
 
-	call, err := c.sendMessage(&req, true)
+	calls, err := c.send([]*Request{&req}, []bool{true}, false, opt.attach)
 	if err != nil {
 		return err
 	}
+	call := calls[0]
 
 	timeout := c.timeout
 	if opt != nil && opt.Timeout > 0 {
@@ -212,6 +460,7 @@ func (c *Client) Call(method string, params, reply interface{}, opts ...*CallOpt
 	}
 
 	t := time.NewTimer(time.Millisecond * time.Duration(timeout))
+	defer t.Stop()
 	select {
 	case err, ok := <-call.done:
 		if !ok {
@@ -228,9 +477,26 @@ func (c *Client) Call(method string, params, reply interface{}, opts ...*CallOpt
 		}
 		return nil
 	case <-t.C:
-		return errors.New(fmt.Sprintf("Request name [%s] timeout %d ms.\n", method, c.timeout))
+		c.cancel(call)
+		return errors.New(fmt.Sprintf("Request name [%s] timeout %d ms.\n", method, timeout))
+	case <-ctx.Done():
+		c.cancel(call)
+		return ctx.Err()
+	}
+}
+
+// 不再等待请求的返回，并通知服务端取消
+func (c *Client) cancel(calls ...*call) {
+	c.forget(calls...)
+	for _, cc := range calls {
+		if cc == nil {
+			continue
+		}
+		err := c.Notify(CancelMethod, map[string]int64{"id": cc.request.ID})
+		if err != nil {
+			c.logger.Log(LevelDebug, "send cancel failed", fieldRequest(cc.request.ID), fieldError(err))
+		}
 	}
-	return nil
 }
 
 // 发送不需要返回
@@ -0,0 +1,74 @@
+package win
+
+import (
+	"github.com/gorilla/websocket"
+)
+
+// 消息编解码，连接建立时按Sec-WebSocket-Protocol协商选择。
+// 协议层统一使用JSON，Codec负责JSON文本和线路格式之间的转换，处理函数不受影响
+type Codec interface {
+	// 子协议名，用于Sec-WebSocket-Protocol协商
+	Name() string
+	// websocket消息类型，websocket.TextMessage或websocket.BinaryMessage
+	MessageType() int
+	// JSON文本转换为线路格式
+	Encode(data []byte) ([]byte, error)
+	// 线路格式转换为JSON文本
+	Decode(data []byte) ([]byte, error)
+}
+
+var (
+	// 默认的JSON文本帧，客户端没有协商子协议时使用
+	JSONCodec Codec = jsonCodec{}
+	// MessagePack二进制帧
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) Encode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (jsonCodec) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (msgpackCodec) Encode(data []byte) ([]byte, error) {
+	return jsonToMsgpack(data)
+}
+
+func (msgpackCodec) Decode(data []byte) ([]byte, error) {
+	return msgpackToJSON(data)
+}
+
+// 按客户端的偏好顺序选择第一个支持的Codec，都不支持时使用JSON。
+// ok表示选中的Codec是客户端提供的子协议之一，只有这时才能在握手响应中返回子协议
+func negotiateCodec(offered []string, codecs []Codec) (codec Codec, ok bool) {
+	for _, name := range offered {
+		for _, codec := range codecs {
+			if codec.Name() == name {
+				return codec, true
+			}
+		}
+	}
+	return JSONCodec, false
+}
@@ -1,25 +1,62 @@
 package win
 
-type globalConfig struct {
-	workerPoolSize uint32
-	workerTaskMax  uint32
-	allowedOrigins []string
-	maxConn        int
+import (
+	"net"
+	"time"
+)
+
+type serverConfig struct {
+	workerPoolSize   uint32
+	workerTaskMax    uint32
+	allowedOrigins   []string
+	maxConn          int
+	readBufferSize   int
+	writeBufferSize  int
+	handshakeTimeout time.Duration
+	pingInterval     time.Duration
+	pongWait         time.Duration
+	writeWait        time.Duration
+	sendQueueSize    int
+	overflow         OverflowPolicy
+	sendTimeout      time.Duration
+	logger           Logger
+	trustedProxies   []*net.IPNet
+	protocol         Protocol
+	codecs           []Codec
+	handlerTimeout   time.Duration
+	callTimeout      time.Duration
+	noReply          NoReplyPolicy
+	readLimit        int64
+	strictBind       bool
 }
 
-var config globalConfig
+// 新建Server时使用的默认配置，通过Set*函数修改，只影响之后创建的Server
+var config serverConfig
 
 func init() {
 	config = defaultConfig()
 }
 
-func defaultConfig() globalConfig {
+func defaultConfig() serverConfig {
 
-	return globalConfig{
-		workerPoolSize: 0,
-		workerTaskMax:  1024,
-		allowedOrigins: []string{},
-		maxConn:        5000,
+	return serverConfig{
+		workerPoolSize:   0,
+		workerTaskMax:    1024,
+		allowedOrigins:   []string{},
+		maxConn:          5000,
+		readBufferSize:   1024,
+		writeBufferSize:  1024,
+		handshakeTimeout: 0,
+		pingInterval:     54 * time.Second,
+		pongWait:         60 * time.Second,
+		writeWait:        10 * time.Second,
+		sendQueueSize:    256,
+		overflow:         OverflowBlock,
+		sendTimeout:      5 * time.Second,
+		logger:           nopLogger{},
+		codecs:           []Codec{JSONCodec, MsgpackCodec},
+		callTimeout:      5 * time.Second,
+		readLimit:        1 << 20,
 	}
 }
 
@@ -38,3 +75,151 @@ func SetAllowedOrigins(origins []string) {
 func SetMaxConn(maxConn int) {
 	config.maxConn = maxConn
 }
+
+// 设置心跳：每pingInterval发送一次ping，超过pongWait未收到任何数据视为断开，
+// writeWait为每次写入的超时。传0关闭对应的功能
+func SetHeartbeat(pingInterval, pongWait, writeWait time.Duration) {
+	config.pingInterval = pingInterval
+	config.pongWait = pongWait
+	config.writeWait = writeWait
+}
+
+// 设置每个连接的发送队列长度和队列满时的处理策略，
+// timeout只对OverflowBlock有效，传0一直阻塞到连接关闭
+func SetSendQueue(size int, policy OverflowPolicy, timeout time.Duration) {
+	config.sendQueueSize = size
+	config.overflow = policy
+	config.sendTimeout = timeout
+}
+
+// Server的配置项，未设置的项使用Set*函数设置的默认值
+type ServerOption func(c *serverConfig)
+
+// Worker池大小，0表示每个请求启动一个goroutine处理
+func WithPoolSize(poolSize uint32) ServerOption {
+	return func(c *serverConfig) {
+		c.workerPoolSize = poolSize
+	}
+}
+
+// 每个Worker的任务队列长度
+func WithTaskSize(taskSize uint32) ServerOption {
+	return func(c *serverConfig) {
+		c.workerTaskMax = taskSize
+	}
+}
+
+// 允许的Origin，支持正则
+func WithAllowedOrigins(origins []string) ServerOption {
+	return func(c *serverConfig) {
+		c.allowedOrigins = origins
+	}
+}
+
+// 最大连接数
+func WithMaxConn(maxConn int) ServerOption {
+	return func(c *serverConfig) {
+		c.maxConn = maxConn
+	}
+}
+
+// websocket读写缓冲区大小
+func WithBufferSize(readBufferSize, writeBufferSize int) ServerOption {
+	return func(c *serverConfig) {
+		c.readBufferSize = readBufferSize
+		c.writeBufferSize = writeBufferSize
+	}
+}
+
+// websocket握手超时，0表示不限制
+func WithHandshakeTimeout(timeout time.Duration) ServerOption {
+	return func(c *serverConfig) {
+		c.handshakeTimeout = timeout
+	}
+}
+
+// 心跳设置，含义同SetHeartbeat
+func WithHeartbeat(pingInterval, pongWait, writeWait time.Duration) ServerOption {
+	return func(c *serverConfig) {
+		c.pingInterval = pingInterval
+		c.pongWait = pongWait
+		c.writeWait = writeWait
+	}
+}
+
+// 发送队列设置，含义同SetSendQueue
+func WithSendQueue(size int, policy OverflowPolicy, timeout time.Duration) ServerOption {
+	return func(c *serverConfig) {
+		c.sendQueueSize = size
+		c.overflow = policy
+		c.sendTimeout = timeout
+	}
+}
+
+// 日志输出，默认不输出
+func WithLogger(logger Logger) ServerOption {
+	return func(c *serverConfig) {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		c.logger = logger
+	}
+}
+
+// 可信代理的IP或CIDR，来自这些地址的连接才会用X-Forwarded-For和X-Real-IP解析客户端IP
+func WithTrustedProxies(proxies ...string) ServerOption {
+	networks := parseTrustedProxies(proxies)
+	return func(c *serverConfig) {
+		c.trustedProxies = networks
+	}
+}
+
+// 消息协议，默认ProtocolWin
+func WithProtocol(protocol Protocol) ServerOption {
+	return func(c *serverConfig) {
+		c.protocol = protocol
+	}
+}
+
+// 可协商的消息编解码，默认支持JSON和MessagePack，
+// 客户端没有请求子协议或请求的都不支持时使用JSON
+func WithCodecs(codecs ...Codec) ServerOption {
+	return func(c *serverConfig) {
+		c.codecs = codecs
+	}
+}
+
+// 每个请求的处理时限，超时后Context.Context()被取消，默认不限制
+func WithHandlerTimeout(timeout time.Duration) ServerOption {
+	return func(c *serverConfig) {
+		c.handlerTimeout = timeout
+	}
+}
+
+// Conn.Call等待客户端回复的时限，默认和客户端一样为5秒，传0只由ctx控制
+func WithCallTimeout(timeout time.Duration) ServerOption {
+	return func(c *serverConfig) {
+		c.callTimeout = timeout
+	}
+}
+
+// 处理函数没有回复时的处理方式，默认NoReplyError
+func WithNoReplyPolicy(policy NoReplyPolicy) ServerOption {
+	return func(c *serverConfig) {
+		c.noReply = policy
+	}
+}
+
+// 单条消息的最大字节数，超过时断开连接，默认1MB，传0不限制
+func WithReadLimit(limit int64) ServerOption {
+	return func(c *serverConfig) {
+		c.readLimit = limit
+	}
+}
+
+// 带类型的处理函数和服务方法解析参数时使用BindStrict，参数中有结构体没有的字段时回复错误
+func WithStrictBind(strict bool) ServerOption {
+	return func(c *serverConfig) {
+		c.strictBind = strict
+	}
+}
@@ -1,44 +1,107 @@
 package win
 
 import (
+	"context"
 	"errors"
 	"github.com/gorilla/websocket"
-	"log"
+	"net"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 发送队列满时的处理策略
+type OverflowPolicy int
+
+const (
+	// 阻塞等待，超过发送超时返回ErrSendTimeout
+	OverflowBlock OverflowPolicy = iota
+	// 丢弃队列中最旧的消息，腾出位置给新消息
+	OverflowDropOldest
+	// 丢弃新消息，返回ErrQueueFull
+	OverflowDropNewest
+	// 关闭慢速连接，返回ErrQueueFull
+	OverflowDisconnect
+)
+
+var (
+	ErrConnClosed  = errors.New("win: conn closed")
+	ErrSendTimeout = errors.New("win: send timeout")
+	ErrQueueFull   = errors.New("win: send queue full")
+	// Server.NotifyConn找不到连接
+	ErrConnNotFound = errors.New("win: conn not found")
 )
 
 type Conn struct {
+	lastSeen   int64 // unix纳秒，atomic访问，放在首位保证64位对齐
 	id         uint32
 	Server     *Server
 	conn       *websocket.Conn
 	msgHandler *msgHandler
 	pool       sync.Pool
-	sendChan   chan Response
-	exitChan   chan bool
+	sendChan   chan []byte
+	format     messageFormat
+	codec      Codec
+	exitChan   chan struct{}
+	drainChan  chan struct{}
+	readDone   chan struct{}
 	mu         sync.Mutex
 	closing    bool
+	draining   bool
 	storeMu    sync.Mutex
 	store      map[string]interface{}
+	handshake  *Handshake
+	identity   interface{}
+	ctx        context.Context
+	cancel     context.CancelFunc
+	reqMu      sync.Mutex
+	requests   map[string]*requestState
+	callMu     sync.Mutex
+	callSeq    int64
+	calls      map[int64]*call
+	rooms      map[string]struct{}
+	userID     string
 }
 
-func newConn(server *Server, id uint32, conn *websocket.Conn, msgHandler *msgHandler) *Conn {
+func newConn(server *Server, id uint32, conn *websocket.Conn, msgHandler *msgHandler, handshake *Handshake, codec Codec) *Conn {
+	queueSize := server.config.sendQueueSize
+	if queueSize < 1 {
+		queueSize = 1
+	}
 	c := &Conn{
 		id:         id,
 		Server:     server,
 		conn:       conn,
 		msgHandler: msgHandler,
-		sendChan:   make(chan Response),
-		exitChan:   make(chan bool),
+		sendChan:   make(chan []byte, queueSize),
+		format:     server.config.protocol.format(),
+		codec:      codec,
+		exitChan:   make(chan struct{}),
+		drainChan:  make(chan struct{}),
+		readDone:   make(chan struct{}),
+		handshake:  handshake,
+		lastSeen:   time.Now().UnixNano(),
+		requests:   make(map[string]*requestState),
+		calls:      make(map[int64]*call),
 	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 	c.pool.New = func() interface{} {
 		return NewContext(nil, nil)
 	}
-	c.Server.register(id, c)
 	return c
 }
 
 func (c *Conn) start() {
-	log.Printf("[win-debug]: conn %d start", c.id)
+	c.logger().Log(LevelDebug, "conn start", fieldConn(c.id))
+	c.touch()
+	c.conn.SetPongHandler(func(string) error {
+		c.touch()
+		return nil
+	})
+	c.conn.SetPingHandler(func(data string) error {
+		c.touch()
+		return replyPong(c.conn, data, c.Server.config.writeWait)
+	})
 	go c.readMessages()
 	go c.writeMessages()
 	if c.Server.connStartCallback != nil {
@@ -47,106 +110,379 @@ func (c *Conn) start() {
 }
 
 func (c *Conn) Close() {
-	defer log.Printf("[win-debug]: conn %d closed", c.id)
 	c.mu.Lock()
 	if c.closing {
 		c.mu.Unlock()
 		return
 	}
 	c.closing = true
+	close(c.exitChan)
 	c.mu.Unlock()
-
-	c.exitChan <- true
+	c.cancel()
+	c.Server.dropUser(c)
 
 	if c.Server.connCloseCallback != nil {
 		c.Server.connCloseCallback(c)
 	}
 
 	c.conn.Close()
-	c.Server.connId = 0
+	c.Server.leaveAll(c)
 	c.Server.remove(c.id)
-	close(c.exitChan)
-	close(c.sendChan)
+	c.logger().Log(LevelDebug, "conn closed", fieldConn(c.id))
+}
+
+// 停止读取新请求，连接保持打开以便发送处理中请求的响应，等待回复的Call返回ErrConnClosed
+func (c *Conn) stopRead() {
+	c.mu.Lock()
+	if c.closing || c.draining {
+		c.mu.Unlock()
+		return
+	}
+	c.draining = true
+	c.conn.SetReadDeadline(time.Now())
+	c.mu.Unlock()
+	// 不再读取客户端的回复，处理函数中的Call不能一直等待
+	c.failCalls(ErrConnClosed)
+}
+
+func (c *Conn) isClosing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closing
+}
+
+func (c *Conn) isDraining() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.draining
+}
+
+func (c *Conn) ID() uint32 {
+	return c.id
+}
+
+// 连接协商使用的消息编解码
+func (c *Conn) Codec() Codec {
+	return c.codec
+}
+
+// 建立连接时的HTTP握手信息
+func (c *Conn) Handshake() *Handshake {
+	return c.handshake
+}
+
+// 客户端真实IP
+func (c *Conn) ClientIP() string {
+	return c.handshake.ClientIP()
+}
+
+// 握手认证得到的身份信息，没有设置认证时为nil
+func (c *Conn) Identity() interface{} {
+	return c.identity
+}
+
+func (c *Conn) logger() Logger {
+	return c.Server.Logger()
+}
+
+// 最后一次收到对端数据（消息、ping或pong）的时间
+func (c *Conn) LastSeen() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastSeen))
+}
+
+// 记录对端活跃并延长读超时
+func (c *Conn) touch() {
+	atomic.StoreInt64(&c.lastSeen, time.Now().UnixNano())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.draining || c.Server.config.pongWait <= 0 {
+		return
+	}
+	c.conn.SetReadDeadline(time.Now().Add(c.Server.config.pongWait))
+}
+
+// 通知写goroutine发送完队列中的消息后以GoingAway关闭连接，写goroutine阻塞时等到ctx结束
+func (c *Conn) drain(ctx context.Context) error {
+	select {
+	case c.drainChan <- struct{}{}:
+	case <-c.exitChan:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
 }
 
 // 读goroutine
 func (c *Conn) readMessages() {
-	log.Printf("[win-debug]: goroutine readMessages runing")
 	defer func() {
-		c.Close()
-		log.Printf("[win-debug]: goroutine readMessages Close")
+		close(c.readDone)
+		// 优雅关闭时由写goroutine负责关闭连接
+		if !c.isDraining() {
+			c.Close()
+		}
 	}()
 	for {
-		var request Request
-		err := c.conn.ReadJSON(&request)
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if !websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("[win-debug]: conn has closed: %v", err)
-				break
+				c.logger().Log(LevelDebug, "conn has closed", fieldConn(c.id), fieldError(err))
 			} else {
-				log.Printf("[win-debug]: read message error: %v", err)
-				continue
+				c.logger().Log(LevelWarn, "read message error", fieldConn(c.id), fieldError(err))
 			}
+			break
 		}
 
-		// 从对象池里取context
-		ctx := c.pool.Get().(*Context)
-		ctx.reset(&request, c)
+		if c.isDraining() {
+			break
+		}
+		c.touch()
 
-		// 使用Worker池
-		if c.msgHandler.workerPoolSize > 0 {
-			c.msgHandler.sendToTaskQueue(*ctx)
-		} else {
-			go c.msgHandler.doHandler(*ctx)
+		if data, err = c.codec.Decode(data); err != nil {
+			c.SendMessage(c.decodeErrorResponse(nil, c.format.parseError(err)))
+			continue
+		}
+		if isBatch(data) {
+			c.handleBatch(data)
+			continue
 		}
 
-		c.pool.Put(ctx)
+		request, resp, err := c.format.decode(data)
+		if err != nil {
+			c.SendMessage(c.decodeErrorResponse(request, err))
+			continue
+		}
+		if resp != nil {
+			c.handleResponse(resp)
+			continue
+		}
+		c.dispatch(request, nil)
 	}
 }
 
+// 批量请求逐个分发，需要回复的请求全部回复后合并成一个数组发送，通知不回复
+func (c *Conn) handleBatch(data []byte) {
+	items, err := splitBatch(c.format, data)
+	if err != nil {
+		c.SendMessage(c.decodeErrorResponse(nil, err))
+		return
+	}
+
+	b := newBatch(c)
+	for _, item := range items {
+		request, resp, err := c.format.decode(item)
+		if err != nil {
+			resp := c.decodeErrorResponse(request, err)
+			if data, err := c.format.encodeResponse(&resp); err == nil {
+				b.add(data)
+			}
+			continue
+		}
+		if resp != nil {
+			c.handleResponse(resp)
+			continue
+		}
+		c.dispatch(request, b)
+	}
+	b.seal()
+}
+
+// 分发请求，b不为nil时请求属于批量请求，其中的通知不回复
+func (c *Conn) dispatch(request *Request, b *batch) {
+	// 从对象池里取context
+	ctx := c.pool.Get().(*Context)
+	ctx.reset(request, c)
+	if b != nil {
+		ctx.inBatch = true
+		if !request.IsNotification() {
+			ctx.batch = b.slot()
+		}
+	}
+
+	if request.Method == CancelMethod {
+		c.handleCancel(*ctx)
+	} else {
+		ctx.state = c.newRequestState(request)
+		c.msgHandler.dispatch(*ctx)
+	}
+
+	c.pool.Put(ctx)
+}
+
+// 无法处理的消息对应的错误响应，识别不出id时id为null
+func (c *Conn) decodeErrorResponse(request *Request, err error) Response {
+	c.logger().Log(LevelDebug, "decode message failed", fieldConn(c.id), fieldError(err))
+	e, ok := err.(*Error)
+	if !ok {
+		e = builtinError(CodeInvalidRequest, err.Error())
+	}
+	resp := Response{Error: e, rawID: jsonNull}
+	if request != nil {
+		resp.ID, resp.rawID = request.ID, request.rawID
+	}
+	return resp
+}
+
 // 写goroutine
 func (c *Conn) writeMessages() {
-	log.Printf("[win-debug]: goroutine writeMessages runing")
-	defer func() {
-		log.Printf("[win-debug]: goroutine writeMessages Close")
-	}()
+	var ping <-chan time.Time
+	if c.Server.config.pingInterval > 0 {
+		ticker := time.NewTicker(c.Server.config.pingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
 	for {
 		select {
 		case <-c.exitChan:
 			return
-		case resp, ok := <-c.sendChan:
-			if !ok {
-				log.Printf("[win-debug]: sendChan closed")
+		case <-ping:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, writeDeadline(c.Server.config.writeWait)); err != nil {
+				c.logger().Log(LevelDebug, "ping failed", fieldConn(c.id), fieldError(err))
+				c.Close()
+				return
+			}
+		case <-c.drainChan:
+			c.flush()
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown")
+			c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			c.Close()
+			return
+		case data := <-c.sendChan:
+			if err := c.write(data); err != nil {
+				c.logger().Log(LevelWarn, "write message failed", fieldConn(c.id), fieldError(err))
+				c.Close()
 				return
 			}
-			c.conn.WriteJSON(resp)
 		}
 	}
 }
 
-// 发送数据
-func (c *Conn) SendMessage(resp Response) {
+func (c *Conn) write(data []byte) error {
+	if c.Server.config.writeWait > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.Server.config.writeWait))
+	}
+	return c.conn.WriteMessage(c.codec.MessageType(), data)
+}
+
+// 发送所有还在排队的消息
+func (c *Conn) flush() {
+	for {
+		select {
+		case data := <-c.sendChan:
+			if err := c.write(data); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// 发送数据，消息进入发送队列后返回nil，队列满时按OverflowPolicy处理
+func (c *Conn) SendMessage(resp Response) error {
+	data, err := c.format.encodeResponse(&resp)
+	if err != nil {
+		return err
+	}
+	return c.enqueue(data)
+}
+
+// 编码后的消息转换为线路格式放入发送队列
+func (c *Conn) enqueue(data []byte) error {
+	return c.queue(data, false)
+}
+
+// 同enqueue，但队列满时不等待，OverflowBlock按OverflowDropNewest处理，用于广播
+func (c *Conn) tryEnqueue(data []byte) error {
+	return c.queue(data, true)
+}
+
+func (c *Conn) queue(data []byte, nonBlocking bool) error {
+	data, err := c.codec.Encode(data)
+	if err != nil {
+		return err
+	}
+
 	c.mu.Lock()
 	if c.closing {
 		c.mu.Unlock()
-		log.Printf("[win-debug]: conn closed when send message")
-		return
+		c.logger().Log(LevelDebug, "conn closed when send message", fieldConn(c.id))
+		return ErrConnClosed
 	}
 	c.mu.Unlock()
-	c.sendChan <- resp
+
+	select {
+	case c.sendChan <- data:
+		return nil
+	default:
+	}
+
+	policy := c.Server.config.overflow
+	if nonBlocking && policy == OverflowBlock {
+		policy = OverflowDropNewest
+	}
+	switch policy {
+	case OverflowDropOldest:
+		for {
+			select {
+			case <-c.sendChan:
+				c.logger().Log(LevelWarn, "send queue full, drop oldest message", fieldConn(c.id))
+			default:
+			}
+			select {
+			case c.sendChan <- data:
+				return nil
+			case <-c.exitChan:
+				return ErrConnClosed
+			default:
+			}
+		}
+	case OverflowDropNewest:
+		c.logger().Log(LevelWarn, "send queue full, drop message", fieldConn(c.id))
+		return ErrQueueFull
+	case OverflowDisconnect:
+		c.logger().Log(LevelWarn, "send queue full, disconnect", fieldConn(c.id))
+		go c.Close()
+		return ErrQueueFull
+	default:
+		var timeout <-chan time.Time
+		if c.Server.config.sendTimeout > 0 {
+			t := time.NewTimer(c.Server.config.sendTimeout)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case c.sendChan <- data:
+			return nil
+		case <-c.exitChan:
+			c.logger().Log(LevelDebug, "conn closed when send message", fieldConn(c.id))
+			return ErrConnClosed
+		case <-timeout:
+			c.logger().Log(LevelWarn, "send timeout", fieldConn(c.id))
+			return ErrSendTimeout
+		}
+	}
+}
+
+// 发送队列中等待发送的消息数
+func (c *Conn) QueueLen() int {
+	return len(c.sendChan)
+}
+
+// 发送队列容量
+func (c *Conn) QueueCap() int {
+	return cap(c.sendChan)
 }
 
-// 取值
-func (c *Conn) get(key string) (interface{}, error) {
+// 取连接上保存的值，连接内所有请求共享
+func (c *Conn) Get(key string) (interface{}, bool) {
+	c.storeMu.Lock()
 	defer c.storeMu.Unlock()
-	if val, ok := c.store[key]; ok {
-		return val, nil
-	}
-	return nil, errors.New("Not exist key: " + key)
+	val, ok := c.store[key]
+	return val, ok
 }
 
-// 设置值
-func (c *Conn) set(key string, val interface{}) {
+// 在连接上保存值，连接内所有请求共享，请求内的值用Context.SetValue
+func (c *Conn) Set(key string, val interface{}) {
 	c.storeMu.Lock()
 	defer c.storeMu.Unlock()
 	if c.store == nil {
@@ -154,3 +490,22 @@ func (c *Conn) set(key string, val interface{}) {
 	}
 	c.store[key] = val
 }
+
+// 写控制帧的截止时间，writeWait为0时使用一个较长的默认值
+func writeDeadline(writeWait time.Duration) time.Time {
+	if writeWait <= 0 {
+		writeWait = time.Minute
+	}
+	return time.Now().Add(writeWait)
+}
+
+// 与gorilla默认的ping处理相同，回复pong
+func replyPong(conn *websocket.Conn, data string, writeWait time.Duration) error {
+	err := conn.WriteControl(websocket.PongMessage, []byte(data), writeDeadline(writeWait))
+	if err == websocket.ErrCloseSent {
+		return nil
+	} else if e, ok := err.(net.Error); ok && e.Temporary() {
+		return nil
+	}
+	return err
+}
@@ -1,12 +1,17 @@
 package win
 
 import (
+	"context"
 	"encoding/json"
 )
 
 type Context struct {
 	Request *Request
 	Conn    *Conn
+	params  []Param
+	batch   *batchSlot
+	inBatch bool
+	state   *requestState
 }
 
 func NewContext(r *Request, conn *Conn) *Context {
@@ -19,53 +24,114 @@ func NewContext(r *Request, conn *Conn) *Context {
 func (c *Context) reset(r *Request, conn *Conn) {
 	c.Request = r
 	c.Conn = conn
+	c.params = nil
+	c.batch = nil
+	c.inBatch = false
+	c.state = nil
 }
 
-func (c *Context) sendMessage(resp Response) {
-	c.Conn.SendMessage(resp)
+// 请求的context，客户端取消请求、连接关闭或超过WithHandlerTimeout设置的时间后取消
+func (c *Context) Context() context.Context {
+	if c.state != nil && c.state.ctx != nil {
+		return c.state.ctx
+	}
+	if c.Conn != nil {
+		return c.Conn.ctx
+	}
+	return context.Background()
+}
+
+// 请求结束：释放context，不再计入Shutdown等待的请求
+func (c *Context) finish() {
+	if c.state == nil {
+		return
+	}
+	c.state.finishOnce.Do(func() {
+		c.Conn.releaseRequest(c.state)
+		// 没有回复的请求（已取消或处理函数没有回复）不会再回复，批量响应不再等待它
+		if c.batch != nil {
+			c.batch.skip()
+		}
+		if c.state.done != nil {
+			c.state.done()
+		}
+	})
+}
+
+func (c *Context) sendMessage(resp Response) error {
+	return c.Conn.SendMessage(resp)
+}
+
+// 回复请求，JSON-RPC的通知、批量请求中的通知和已取消的请求不回复，批量请求中的回复合并发送
+func (c *Context) reply(resp Response) error {
+	if c.Request.IsNotification() && (c.inBatch || c.Conn.Server.config.protocol == ProtocolJSONRPC) {
+		return nil
+	}
+	if c.state.isCanceled() {
+		if c.batch != nil {
+			c.batch.skip()
+		}
+		return ErrRequestCanceled
+	}
+	if !c.markReplied() {
+		c.Conn.logger().Log(LevelWarn, "duplicate reply", c.logFields()...)
+		return ErrAlreadyReplied
+	}
+	// 异步请求在回复发出后结束
+	if c.isAsync() {
+		defer c.unref()
+	}
+	c.intercept(&resp)
+	if c.batch != nil {
+		return c.batch.reply(&resp)
+	}
+	return c.sendMessage(resp)
 }
 
 // 返回数据
-func (c *Context) Reply(data interface{}) {
+func (c *Context) Reply(data interface{}) error {
 	resp := Response{
 		Method: c.Request.Method,
 		ID:     c.Request.ID,
 		Error:  nil,
+		rawID:  c.Request.rawID,
+	}
+	if err := resp.setResult(data); err != nil {
+		return err
 	}
-	resp.setResult(data)
-	c.sendMessage(resp)
+	return c.reply(resp)
 }
 
 // 返回错误信息
-func (c *Context) ReplyError(code int, msg string, data ...interface{}) {
-	var errData interface{}
-	if len(data) > 0 {
-		errData = data[0]
-	}
+func (c *Context) ReplyError(code int, msg string, data ...interface{}) error {
+	return c.replyError(NewError(code, msg, data...))
+}
+
+func (c *Context) replyError(e *Error) error {
 	resp := Response{
 		Method: c.Request.Method,
 		ID:     c.Request.ID,
-		Error: &Error{
-			Code:    code,
-			Message: msg,
-			Data:    errData,
-		},
+		rawID:  c.Request.rawID,
+		Error:  e,
 	}
-	c.sendMessage(resp)
+	return c.reply(resp)
 }
 
-// 推送给客户端的数据
-func (c *Context) Notify(data interface{}) {
+// 推送给客户端的数据，JSON-RPC模式下不是对象或数组的数据包装为[data]
+func (c *Context) Notify(data interface{}) error {
 	resp := Response{
 		Method: c.Request.Method,
 		ID:     0,
 		Error:  nil,
 	}
-	resp.setResult(data)
-	c.sendMessage(resp)
+	if err := resp.setResult(data); err != nil {
+		return err
+	}
+	c.intercept(&resp)
+	return c.sendMessage(resp)
 }
 
-func (c *Context) NotifyError(code int, msg string, data ...interface{}) {
+func (c *Context) NotifyError(code int, msg string, data ...interface{}) error {
 	var errData interface{}
 	if len(data) > 0 {
 		errData = data[0]
@@ -73,28 +139,62 @@ func (c *Context) NotifyError(code int, msg string, data ...interface{}) {
 	resp := Response{
 		Method: c.Request.Method,
 		ID:     c.Request.ID,
+		rawID:  c.Request.rawID,
 		Error: &Error{
 			Code:    code,
 			Message: msg,
 			Data:    errData,
 		},
 	}
-	c.sendMessage(resp)
+	c.intercept(&resp)
+	return c.sendMessage(resp)
+}
+
+// 路由参数，如/room/:roomId/send中的roomId，不存在时返回空字符串
+func (c *Context) Param(name string) string {
+	for _, p := range c.params {
+		if p.Key == name {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// 建立连接时的HTTP握手信息
+func (c *Context) Handshake() *Handshake {
+	return c.Conn.Handshake()
+}
+
+// 客户端真实IP
+func (c *Context) ClientIP() string {
+	return c.Conn.ClientIP()
 }
 
-// 取值
-func (c *Context) Get(key string) (interface{}, error) {
-	return c.Conn.get(key)
+// 握手认证得到的身份信息
+func (c *Context) Identity() interface{} {
+	return c.Conn.Identity()
 }
 
-// 设置值
-func (c *Context) Set(key string, val interface{}) {
-	c.Conn.set(key, val)
+// 把error交给ErrorHandler转换为错误响应
+func (c *Context) handleError(err error) {
+	h := c.Conn.msgHandler.errorHandler
+	if h == nil {
+		h = defaultErrorHandler
+	}
+	h(*c, err)
 }
 
 func (c *Context) BindJson(ptr interface{}) error {
+	if c.Request.Params == nil {
+		return nil
+	}
 	if err := json.Unmarshal(*c.Request.Params, ptr); err != nil {
 		return err
 	}
 	return nil
 }
+
+// 日志中标识当前请求的字段
+func (c *Context) logFields() []Field {
+	return []Field{fieldConn(c.Conn.id), fieldMethod(c.Request.Method), fieldRequest(c.Request.ID)}
+}
@@ -0,0 +1,61 @@
+package win
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 把处理函数返回的错误转换为响应，默认*Error保留错误码和数据，其他错误回复CodeInternalError，错误信息只记录日志
+type ErrorHandler func(ctx Context, err error)
+
+// 新建带错误码的错误，处理函数返回后按错误码回复
+func NewError(code int, msg string, data ...interface{}) *Error {
+	e := &Error{Code: code, Message: msg}
+	if len(data) > 0 {
+		e.Data = data[0]
+	}
+	return e
+}
+
+// 框架生成的错误
+func builtinError(code int, msg string, data ...interface{}) *Error {
+	e := NewError(code, msg, data...)
+	e.builtin = true
+	return e
+}
+
+// 处理函数panic时交给ErrorHandler的错误
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("win: handler panic: %v", e.Value)
+}
+
+// 返回error的处理函数转换为HandlerFunc，返回的错误交给ErrorHandler
+func HandlerE(h HandlerFuncE) HandlerFunc {
+	return func(ctx Context) {
+		if err := h(ctx); err != nil {
+			ctx.handleError(err)
+		}
+	}
+}
+
+// 只有*Error回复给客户端，panic和其他错误的细节可能包含内部信息，只记录日志
+func defaultErrorHandler(ctx Context, err error) {
+	var e *Error
+	var p *PanicError
+	switch {
+	case errors.As(err, &e):
+		// 复制一份，保留框架错误的标记
+		reply := *e
+		ctx.replyError(&reply)
+	case errors.As(err, &p):
+		ctx.replyError(builtinError(CodeInternalError, "internal error"))
+	default:
+		ctx.Conn.logger().Log(LevelError, "handler error", append(ctx.logFields(), fieldError(err))...)
+		ctx.replyError(builtinError(CodeInternalError, "internal error"))
+	}
+}
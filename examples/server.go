@@ -24,6 +24,14 @@ func Hello(c win.Context) {
 	c.Reply(helloRequest)
 }
 
+type HelloRequest struct {
+	Name string
+}
+
+func TypedHello(c *win.Context, req *HelloRequest) (*HelloResponse, error) {
+	return &HelloResponse{Name: fmt.Sprintf("%s%d", req.Name, c.Request.ID)}, nil
+}
+
 func logMiddleware(next win.HandlerFunc) win.HandlerFunc {
 	return func(ctx win.Context) {
 		fmt.Println("request id:", ctx.Request.ID)
@@ -40,6 +48,7 @@ func main() {
 	s.Use(logMiddleware)
 
 	s.AddHandler("hello", Hello)
+	s.AddTypedHandler("typedHello", TypedHello)
 
 	http.HandleFunc("/ws", s.Serve)
 
@@ -1,7 +1,6 @@
 package win
 
 import (
-	"fmt"
 	"strings"
 )
 
@@ -29,14 +28,23 @@ func (g *Group) Use(middleware ...MiddlewareFunc) {
 
 func (g *Group) AddHandler(name string, h HandlerFunc, middleware ...MiddlewareFunc) {
 	path := g.getPrefix() + "/" + name
-	fmt.Println(path)
 	path = strings.ReplaceAll(path, "//", "/")
-	m := make([]MiddlewareFunc, len(g.middleware)+len(middleware))
+	m := make([]MiddlewareFunc, 0, len(g.middleware)+len(middleware))
 	m = append(m, g.middleware...)
 	m = append(m, middleware...)
 	g.server.AddHandler(path, h, m...)
 }
 
+// 注册返回error的处理函数，见HandlerE
+func (g *Group) AddHandlerE(name string, h HandlerFuncE, middleware ...MiddlewareFunc) {
+	g.AddHandler(name, HandlerE(h), middleware...)
+}
+
+// 注册带类型的处理函数，见Typed
+func (g *Group) AddTypedHandler(name string, fn interface{}, middleware ...MiddlewareFunc) {
+	g.AddHandler(name, Typed(fn), middleware...)
+}
+
 func (g *Group) Group(prefix string, middleware ...MiddlewareFunc) Group {
 	if prefix == "/" {
 		prefix = ""
@@ -49,7 +57,7 @@ func (g *Group) Group(prefix string, middleware ...MiddlewareFunc) Group {
 		server: g.server,
 		parent: g,
 	}
-	group.middleware = make([]MiddlewareFunc, len(g.middleware)+len(middleware))
+	group.middleware = make([]MiddlewareFunc, 0, len(g.middleware)+len(middleware))
 	group.middleware = append(group.middleware, g.middleware...)
 	group.middleware = append(group.middleware, middleware...)
 	return group
@@ -0,0 +1,172 @@
+package win
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// 建立连接时HTTP握手请求的只读快照，返回的值都是副本，修改不会影响连接
+type Handshake struct {
+	header     http.Header
+	url        url.URL
+	host       string
+	remoteAddr string
+	cookies    []*http.Cookie
+	tls        *tls.ConnectionState
+	clientIP   string
+}
+
+func newHandshake(r *http.Request, trustedProxies []*net.IPNet) *Handshake {
+	h := &Handshake{
+		header:     r.Header.Clone(),
+		host:       r.Host,
+		remoteAddr: r.RemoteAddr,
+		cookies:    r.Cookies(),
+	}
+	if r.URL != nil {
+		h.url = *r.URL
+	}
+	if r.TLS != nil {
+		state := *r.TLS
+		h.tls = &state
+	}
+	h.clientIP = resolveClientIP(r, trustedProxies)
+	return h
+}
+
+func (h *Handshake) Header() http.Header {
+	return h.header.Clone()
+}
+
+func (h *Handshake) GetHeader(key string) string {
+	return h.header.Get(key)
+}
+
+func (h *Handshake) URL() *url.URL {
+	u := h.url
+	return &u
+}
+
+func (h *Handshake) Query() url.Values {
+	return h.url.Query()
+}
+
+func (h *Handshake) GetQuery(key string) string {
+	return h.url.Query().Get(key)
+}
+
+func (h *Handshake) Cookies() []*http.Cookie {
+	cookies := make([]*http.Cookie, len(h.cookies))
+	for i, cookie := range h.cookies {
+		c := *cookie
+		cookies[i] = &c
+	}
+	return cookies
+}
+
+// 按名字取cookie，不存在返回nil
+func (h *Handshake) Cookie(name string) *http.Cookie {
+	for _, cookie := range h.cookies {
+		if cookie.Name == name {
+			c := *cookie
+			return &c
+		}
+	}
+	return nil
+}
+
+func (h *Handshake) Host() string {
+	return h.host
+}
+
+func (h *Handshake) RemoteAddr() string {
+	return h.remoteAddr
+}
+
+func (h *Handshake) UserAgent() string {
+	return h.header.Get("User-Agent")
+}
+
+// TLS连接状态，非TLS连接返回nil
+func (h *Handshake) TLS() *tls.ConnectionState {
+	if h.tls == nil {
+		return nil
+	}
+	state := *h.tls
+	return &state
+}
+
+// 客户端真实IP，只有来自可信代理的连接才会使用X-Forwarded-For和X-Real-IP
+func (h *Handshake) ClientIP() string {
+	return h.clientIP
+}
+
+func resolveClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	remoteIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		remoteIP = host
+	}
+	if !isTrustedProxy(remoteIP, trustedProxies) {
+		return remoteIP
+	}
+
+	// 从右往左跳过可信代理，第一个不可信的地址就是客户端
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		ips := strings.Split(forwarded, ",")
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(ips[i])
+			if ip == "" {
+				continue
+			}
+			if i == 0 || !isTrustedProxy(ip, trustedProxies) {
+				return ip
+			}
+		}
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	return remoteIP
+}
+
+func isTrustedProxy(ip string, trustedProxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析可信代理，支持CIDR和单个IP
+func parseTrustedProxies(proxies []string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				panic("invalid trusted proxy: " + proxy)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			panic("invalid trusted proxy: " + proxy)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
@@ -0,0 +1,32 @@
+package win
+
+// 响应拦截器，在Reply、ReplyError、Notify、NotifyError、Stream.Send和Progress发送前执行，
+// 可以修改resp的Headers、Result、Error或整个替换，id保持为原请求的id
+type ResponseInterceptor func(ctx Context, resp *Response)
+
+// 只对当前请求的响应生效的拦截器，在Server.UseInterceptor注册的之后执行
+func (c *Context) Intercept(interceptors ...ResponseInterceptor) {
+	st := c.requestState()
+	st.mu.Lock()
+	st.interceptors = append(st.interceptors, interceptors...)
+	st.mu.Unlock()
+}
+
+func (c *Context) intercept(resp *Response) {
+	interceptors := c.Conn.msgHandler.interceptors
+	if c.state != nil {
+		c.state.mu.Lock()
+		if len(c.state.interceptors) > 0 {
+			interceptors = append(interceptors[:len(interceptors):len(interceptors)], c.state.interceptors...)
+		}
+		c.state.mu.Unlock()
+	}
+	if len(interceptors) == 0 {
+		return
+	}
+	id, rawID := resp.ID, resp.rawID
+	for _, i := range interceptors {
+		i(*c, resp)
+	}
+	resp.ID, resp.rawID = id, rawID
+}
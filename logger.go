@@ -0,0 +1,134 @@
+package win
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+)
+
+// 日志级别
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// 结构化日志字段
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// 日志接口，Server和Client默认不输出任何日志
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(Level, string, ...Field) {}
+
+// 丢弃所有日志的Logger
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+type stdLogger struct {
+	l        *log.Logger
+	minLevel Level
+}
+
+// 使用标准库log.Logger输出不低于minLevel的日志，l为nil时使用log包的标准输出
+func NewStdLogger(l *log.Logger, minLevel Level) Logger {
+	return &stdLogger{l: l, minLevel: minLevel}
+}
+
+func (s *stdLogger) Log(level Level, msg string, fields ...Field) {
+	if level < s.minLevel {
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString("[win] ")
+	buf.WriteString(level.String())
+	buf.WriteByte(' ')
+	buf.WriteString(msg)
+	for _, f := range fields {
+		fmt.Fprintf(&buf, " %s=%v", f.Key, f.Value)
+	}
+	if s.l == nil {
+		log.Output(2, buf.String())
+		return
+	}
+	s.l.Output(2, buf.String())
+}
+
+// log/slog风格的日志接口，*slog.Logger满足该接口
+type SlogLogger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type slogAdapter struct {
+	l SlogLogger
+}
+
+// 把slog风格的日志适配为Logger，字段以key、value交替的形式传入
+func NewSlogLogger(l SlogLogger) Logger {
+	return &slogAdapter{l: l}
+}
+
+func (s *slogAdapter) Log(level Level, msg string, fields ...Field) {
+	args := make([]interface{}, 0, len(fields)*2)
+	for _, f := range fields {
+		args = append(args, f.Key, f.Value)
+	}
+	switch {
+	case level >= LevelError:
+		s.l.Error(msg, args...)
+	case level >= LevelWarn:
+		s.l.Warn(msg, args...)
+	case level >= LevelInfo:
+		s.l.Info(msg, args...)
+	default:
+		s.l.Debug(msg, args...)
+	}
+}
+
+func fieldConn(id uint32) Field {
+	return F("conn_id", id)
+}
+
+func fieldMethod(method string) Field {
+	return F("method", method)
+}
+
+func fieldRequest(id int64) Field {
+	return F("request_id", id)
+}
+
+func fieldError(err error) Field {
+	return F("error", err)
+}
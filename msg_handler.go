@@ -1,23 +1,34 @@
 package win
 
 import (
-	"log"
+	"runtime/debug"
+	"sync"
 )
 
 type msgHandler struct {
-	handlers        map[string]HandlerFunc
+	router          *router
 	middleware      []MiddlewareFunc
+	interceptors    []ResponseInterceptor
 	workerPoolSize  uint32
 	taskQueue       []chan Context
 	notFoundHandler HandlerFunc
+	errorHandler    ErrorHandler
+	inflight        sync.WaitGroup
+	quit            chan struct{}
+	quitOnce        sync.Once
+	workerTaskMax   uint32
+	logger          Logger
 }
 
-func newMsgHandler() *msgHandler {
+func newMsgHandler(workerPoolSize, workerTaskMax uint32, logger Logger) *msgHandler {
 	m := &msgHandler{
-		handlers:       make(map[string]HandlerFunc),
+		router:         newRouter(),
 		middleware:     make([]MiddlewareFunc, 0),
-		workerPoolSize: config.workerPoolSize,
-		taskQueue:      make([]chan Context, config.workerPoolSize),
+		workerPoolSize: workerPoolSize,
+		workerTaskMax:  workerTaskMax,
+		logger:         logger,
+		taskQueue:      make([]chan Context, workerPoolSize),
+		quit:           make(chan struct{}),
 	}
 	return m
 }
@@ -27,26 +38,21 @@ func (m *msgHandler) use(middleware ...MiddlewareFunc) {
 	m.middleware = append(m.middleware, middleware...)
 }
 
+// 注册处理函数，name支持命名参数(/room/:roomId/send)和通配(/files/*path)
 func (m *msgHandler) handlerFunc(name string, h HandlerFunc, middleware ...MiddlewareFunc) {
-	if _, ok := m.handlers[name]; ok {
-		panic("Repeated handler name: " + name)
-	}
-	log.Printf("[win-debug]: add handler %s", name)
-	m.handlers[name] = func(ctx Context) {
-		h = applyMiddleware(h, middleware...)
-		h(ctx)
-	}
+	m.router.add(name, applyMiddleware(h, middleware...))
+	m.logger.Log(LevelDebug, "add handler", fieldMethod(name))
 }
 
 func (m *msgHandler) doHandler(c Context) {
-	log.Printf("[win-debug]: invoke handler %s", c.Request.Method)
-	h, ok := m.handlers[c.Request.Method]
+	h, params, ok := m.router.find(c.Request.Method)
+	c.params = params
 	if !ok {
-		log.Printf("[win-debug]: invoke handler name %s not exists", c.Request.Method)
+		m.logger.Log(LevelDebug, "handler not found", c.logFields()...)
 		if m.notFoundHandler != nil {
 			m.notFoundHandler(c)
 		} else {
-			c.ReplyError(404, "not found")
+			c.handleError(builtinError(CodeNotFound, "not found"))
 		}
 		return
 	}
@@ -54,9 +60,42 @@ func (m *msgHandler) doHandler(c Context) {
 	h(c)
 }
 
+func (m *msgHandler) handle(c Context) {
+	defer c.handlerReturned()
+	defer m.recover(c)
+	m.doHandler(c)
+}
+
+// 处理函数panic时记录堆栈，交给ErrorHandler回复，不影响其他请求
+func (m *msgHandler) recover(c Context) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err := &PanicError{Value: r, Stack: debug.Stack()}
+	m.logger.Log(LevelError, "handler panic", append(c.logFields(), F("panic", r), F("stack", string(err.Stack)))...)
+	defer func() {
+		if r := recover(); r != nil {
+			m.logger.Log(LevelError, "error handler panic", append(c.logFields(), F("panic", r))...)
+		}
+	}()
+	c.handleError(err)
+}
+
+// 分发请求，处理中、排队中和等待异步回复的请求都计入inflight，请求结束时减一
+func (m *msgHandler) dispatch(c Context) {
+	m.inflight.Add(1)
+	c.state.done = m.inflight.Done
+	// 使用Worker池
+	if m.workerPoolSize > 0 {
+		m.sendToTaskQueue(c)
+		return
+	}
+	go m.handle(c)
+}
+
 func (m *msgHandler) sendToTaskQueue(c Context) {
 	workerId := c.Conn.id % m.workerPoolSize
-	log.Printf("[win-debug]: send message to worker, worker id: %d", workerId)
 	m.taskQueue[workerId] <- c
 }
 
@@ -64,20 +103,29 @@ func (m *msgHandler) startWorker(i uint32, taskQueue chan Context) {
 	for {
 		select {
 		case ctx := <-taskQueue:
-			m.doHandler(ctx)
+			m.handle(ctx)
+		case <-m.quit:
+			return
 		}
 	}
 }
 
 func (m *msgHandler) startWorkerPool() {
-	log.Printf("[win-debug]: start worker poll, size: %d", m.workerPoolSize)
+	m.logger.Log(LevelDebug, "start worker pool", F("size", m.workerPoolSize))
 	var i uint32
 	for i = 0; i < m.workerPoolSize; i++ {
-		m.taskQueue[i] = make(chan Context, config.workerTaskMax)
+		m.taskQueue[i] = make(chan Context, m.workerTaskMax)
 		go m.startWorker(i, m.taskQueue[i])
 	}
 }
 
+// 停止所有Worker
+func (m *msgHandler) stopWorkerPool() {
+	m.quitOnce.Do(func() {
+		close(m.quit)
+	})
+}
+
 func applyMiddleware(h HandlerFunc, middleware ...MiddlewareFunc) HandlerFunc {
 	for i := len(middleware) - 1; i >= 0; i-- {
 		h = middleware[i](h)
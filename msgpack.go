@@ -0,0 +1,335 @@
+package win
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// JSON和MessagePack之间的转换，只用到JSON能表示的类型：
+// nil、bool、整数、浮点数、字符串、数组和map，二进制数据转换为base64字符串
+
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeMsgpack(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func msgpackToJSON(data []byte) ([]byte, error) {
+	r := &msgpackReader{data: data}
+	v, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(r.data) {
+		return nil, errors.New("msgpack: trailing data")
+	}
+	return json.Marshal(v)
+}
+
+func writeMsgpack(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			writeMsgpackInt(buf, i)
+		} else if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			buf.WriteByte(0xcf)
+			writeUint(buf, u, 8)
+		} else {
+			f, err := v.Float64()
+			if err != nil {
+				return err
+			}
+			buf.WriteByte(0xcb)
+			writeUint(buf, math.Float64bits(f), 8)
+		}
+	case string:
+		n := len(v)
+		switch {
+		case n < 32:
+			buf.WriteByte(0xa0 | byte(n))
+		case n <= math.MaxUint8:
+			buf.WriteByte(0xd9)
+			writeUint(buf, uint64(n), 1)
+		case n <= math.MaxUint16:
+			buf.WriteByte(0xda)
+			writeUint(buf, uint64(n), 2)
+		default:
+			buf.WriteByte(0xdb)
+			writeUint(buf, uint64(n), 4)
+		}
+		buf.WriteString(v)
+	case []interface{}:
+		writeMsgpackLen(buf, len(v), 0x90, 0xdc, 0xdd)
+		for _, item := range v {
+			if err := writeMsgpack(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		writeMsgpackLen(buf, len(v), 0x80, 0xde, 0xdf)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := writeMsgpack(buf, k); err != nil {
+				return err
+			}
+			if err := writeMsgpack(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+	return nil
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 127:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		writeUint(buf, uint64(i), 2)
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		writeUint(buf, uint64(i), 4)
+	default:
+		buf.WriteByte(0xd3)
+		writeUint(buf, uint64(i), 8)
+	}
+}
+
+// 数组和map的长度头
+func writeMsgpackLen(buf *bytes.Buffer, n int, fix, b16, b32 byte) {
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(b16)
+		writeUint(buf, uint64(n), 2)
+	default:
+		buf.WriteByte(b32)
+		writeUint(buf, uint64(n), 4)
+	}
+}
+
+// 大端写入size个字节
+func writeUint(buf *bytes.Buffer, u uint64, size int) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], u)
+	buf.Write(b[8-size:])
+}
+
+// 数组和map的最大嵌套深度，和encoding/json一致，防止恶意数据导致栈溢出
+const msgpackMaxDepth = 10000
+
+type msgpackReader struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+var (
+	errMsgpackShort = errors.New("msgpack: unexpected end of data")
+	errMsgpackDepth = errors.New("msgpack: exceeded max depth")
+)
+
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, errMsgpackShort
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *msgpackReader) uint(size int) (uint64, error) {
+	b, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func (r *msgpackReader) read() (interface{}, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c >= 0xa0 && c <= 0xbf:
+		return r.str(int(c & 0x1f))
+	case c >= 0x90 && c <= 0x9f:
+		return r.array(int(c & 0x0f))
+	case c >= 0x80 && c <= 0x8f:
+		return r.mapping(int(c & 0x0f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return r.uint(1 << (c - 0xcc))
+	case 0xd0:
+		u, err := r.uint(1)
+		return int64(int8(u)), err
+	case 0xd1:
+		u, err := r.uint(2)
+		return int64(int16(u)), err
+	case 0xd2:
+		u, err := r.uint(4)
+		return int64(int32(u)), err
+	case 0xd3:
+		u, err := r.uint(8)
+		return int64(u), err
+	case 0xca:
+		u, err := r.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return checkFloat(float64(math.Float32frombits(uint32(u))))
+	case 0xcb:
+		u, err := r.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return checkFloat(math.Float64frombits(u))
+	case 0xd9, 0xda, 0xdb:
+		n, err := r.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return r.str(int(n))
+	case 0xc4, 0xc5, 0xc6:
+		n, err := r.uint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		b, err := r.next(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case 0xdc, 0xdd:
+		n, err := r.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.array(int(n))
+	case 0xde, 0xdf:
+		n, err := r.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.mapping(int(n))
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", c)
+}
+
+func (r *msgpackReader) str(n int) (interface{}, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (r *msgpackReader) array(n int) (interface{}, error) {
+	// 每个元素至少一个字节，防止伪造的长度导致大量分配
+	if n > len(r.data)-r.pos {
+		return nil, errMsgpackShort
+	}
+	if r.depth++; r.depth > msgpackMaxDepth {
+		return nil, errMsgpackDepth
+	}
+	defer func() { r.depth-- }()
+	items := make([]interface{}, n)
+	for i := range items {
+		v, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		items[i] = v
+	}
+	return items, nil
+}
+
+func (r *msgpackReader) mapping(n int) (interface{}, error) {
+	if n > (len(r.data)-r.pos)/2 {
+		return nil, errMsgpackShort
+	}
+	if r.depth++; r.depth > msgpackMaxDepth {
+		return nil, errMsgpackDepth
+	}
+	defer func() { r.depth-- }()
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		switch k := k.(type) {
+		case string:
+			m[k] = v
+		case []byte:
+			m[string(k)] = v
+		default:
+			m[fmt.Sprint(k)] = v
+		}
+	}
+	return m, nil
+}
+
+func checkFloat(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, errors.New("msgpack: NaN and Inf can't be converted to JSON")
+	}
+	return f, nil
+}
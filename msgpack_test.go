@@ -0,0 +1,210 @@
+package win
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMsgpackNumbers(t *testing.T) {
+	tests := []struct {
+		json   string
+		header byte
+	}{
+		{"0", 0x00},
+		{"127", 0x7f},
+		{"128", 0xd1},
+		{"-1", 0xff},
+		{"-32", 0xe0},
+		{"-33", 0xd0},
+		{"-128", 0xd0},
+		{"-129", 0xd1},
+		{"32767", 0xd1},
+		{"32768", 0xd2},
+		{"-32769", 0xd2},
+		{"2147483647", 0xd2},
+		{"2147483648", 0xd3},
+		{"-2147483649", 0xd3},
+		{"9223372036854775807", 0xd3},
+		{"-9223372036854775808", 0xd3},
+		{"9223372036854775808", 0xcf},
+		{"18446744073709551615", 0xcf},
+		{"1.5", 0xcb},
+		{"-0.25", 0xcb},
+		{"1e+308", 0xcb},
+		{"5e-324", 0xcb},
+	}
+	for _, tt := range tests {
+		data := roundTrip(t, tt.json)
+		if data[0] != tt.header {
+			t.Errorf("%s: header 0x%02x, want 0x%02x", tt.json, data[0], tt.header)
+		}
+	}
+}
+
+// 编码时不会产生的类型也要能解码
+func TestMsgpackDecodeTypes(t *testing.T) {
+	tests := []struct {
+		data []byte
+		json string
+	}{
+		{[]byte{0xcc, 0xff}, "255"},
+		{[]byte{0xcd, 0xff, 0xff}, "65535"},
+		{[]byte{0xce, 0xff, 0xff, 0xff, 0xff}, "4294967295"},
+		{[]byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, "1.5"},
+		{[]byte{0xc4, 0x02, 'h', 'i'}, `"aGk="`},
+		{[]byte{0x81, 0x01, 0xc3}, `{"1":true}`},
+	}
+	for _, tt := range tests {
+		got, err := msgpackToJSON(tt.data)
+		if err != nil {
+			t.Errorf("% x: %v", tt.data, err)
+			continue
+		}
+		if string(got) != tt.json {
+			t.Errorf("% x: got %s, want %s", tt.data, got, tt.json)
+		}
+	}
+}
+
+func TestMsgpackStrings(t *testing.T) {
+	tests := []struct {
+		n      int
+		header byte
+	}{
+		{0, 0xa0},
+		{31, 0xbf},
+		{32, 0xd9},
+		{255, 0xd9},
+		{256, 0xda},
+		{65535, 0xda},
+		{65536, 0xdb},
+	}
+	for _, tt := range tests {
+		s := `"` + strings.Repeat("a", tt.n) + `"`
+		data := roundTrip(t, s)
+		if data[0] != tt.header {
+			t.Errorf("len %d: header 0x%02x, want 0x%02x", tt.n, data[0], tt.header)
+		}
+	}
+}
+
+func TestMsgpackContainers(t *testing.T) {
+	tests := []struct {
+		n                int
+		array, mapHeader byte
+	}{
+		{0, 0x90, 0x80},
+		{15, 0x9f, 0x8f},
+		{16, 0xdc, 0xde},
+		{65535, 0xdc, 0xde},
+		{65536, 0xdd, 0xdf},
+	}
+	for _, tt := range tests {
+		items := make([]interface{}, tt.n)
+		m := make(map[string]interface{}, tt.n)
+		for i := range items {
+			items[i] = i
+			m[strconv.Itoa(i)] = i
+		}
+		for _, c := range []struct {
+			v      interface{}
+			header byte
+		}{{items, tt.array}, {m, tt.mapHeader}} {
+			s, err := json.Marshal(c.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := roundTrip(t, string(s))
+			if data[0] != c.header {
+				t.Errorf("len %d: header 0x%02x, want 0x%02x", tt.n, data[0], c.header)
+			}
+		}
+	}
+}
+
+func TestMsgpackTruncated(t *testing.T) {
+	for _, s := range []string{
+		`-129`, `2147483648`, `18446744073709551615`, `1.5`,
+		`"` + strings.Repeat("a", 40) + `"`,
+		`"` + strings.Repeat("a", 300) + `"`,
+		`[1,2,[3,"x"]]`,
+		`{"a":{"b":[null,true,false]}}`,
+	} {
+		data, err := jsonToMsgpack([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(data); i++ {
+			if _, err := msgpackToJSON(data[:i]); err == nil {
+				t.Errorf("%s: no error for %d of %d bytes", s, i, len(data))
+			}
+		}
+		if _, err := msgpackToJSON(append(data, 0xc0)); err == nil {
+			t.Errorf("%s: no error for trailing data", s)
+		}
+	}
+}
+
+// 伪造的长度不能导致大量分配
+func TestMsgpackOversizedLength(t *testing.T) {
+	for _, data := range [][]byte{
+		{0xdb, 0xff, 0xff, 0xff, 0xff},
+		{0xc6, 0xff, 0xff, 0xff, 0xff},
+		{0xdd, 0xff, 0xff, 0xff, 0xff},
+		{0xdf, 0xff, 0xff, 0xff, 0xff},
+		{0xdc, 0xff, 0xff, 0x01},
+		{0xde, 0x00, 0x02, 0x01, 0x01, 0x02},
+	} {
+		if _, err := msgpackToJSON(data); err != errMsgpackShort {
+			t.Errorf("% x: got %v, want %v", data, err, errMsgpackShort)
+		}
+	}
+}
+
+func TestMsgpackDepth(t *testing.T) {
+	s := strings.Repeat("[", msgpackMaxDepth) + strings.Repeat("]", msgpackMaxDepth)
+	roundTrip(t, s)
+
+	for _, c := range []byte{0x91, 0x81} {
+		data := bytes.Repeat([]byte{c}, 8<<20)
+		if _, err := msgpackToJSON(data); err != errMsgpackDepth {
+			t.Errorf("0x%02x: got %v, want %v", c, err, errMsgpackDepth)
+		}
+	}
+	data := append(bytes.Repeat([]byte{0x91}, msgpackMaxDepth+1), 0xc0)
+	if _, err := msgpackToJSON(data); err != errMsgpackDepth {
+		t.Errorf("got %v, want %v", err, errMsgpackDepth)
+	}
+}
+
+func TestMsgpackNaN(t *testing.T) {
+	for _, data := range [][]byte{
+		{0xcb, 0x7f, 0xf8, 0, 0, 0, 0, 0, 1},
+		{0xcb, 0x7f, 0xf0, 0, 0, 0, 0, 0, 0},
+		{0xca, 0x7f, 0x80, 0, 0},
+	} {
+		if _, err := msgpackToJSON(data); err == nil {
+			t.Errorf("% x: no error", data)
+		}
+	}
+}
+
+// JSON转换为msgpack再转换回来应该不变，返回msgpack数据
+func roundTrip(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := jsonToMsgpack([]byte(s))
+	if err != nil {
+		t.Fatalf("encode %.40s: %v", s, err)
+	}
+	got, err := msgpackToJSON(data)
+	if err != nil {
+		t.Fatalf("decode %.40s: %v", s, err)
+	}
+	if string(got) != s {
+		t.Fatalf("got %.40s, want %.40s", got, s)
+	}
+	return data
+}
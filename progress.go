@@ -0,0 +1,52 @@
+package win
+
+import (
+	"encoding/json"
+)
+
+// 保留的进度通知方法，参数为{"id": 请求id, "percent": 百分比, "message": 说明, "data": 数据}
+const ProgressMethod = "$/progress"
+
+// 客户端收到的进度
+type Progress struct {
+	Percent float64         `json:"percent"`
+	Message string          `json:"message,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+type progressNotice struct {
+	ID      *json.RawMessage `json:"id"`
+	Percent float64          `json:"percent"`
+	Message string           `json:"message,omitempty"`
+	Data    interface{}      `json:"data,omitempty"`
+}
+
+// 向客户端报告当前请求的处理进度，在最终回复之前发送
+func (c *Context) Progress(percent float64, message string, data interface{}) error {
+	return c.notifyRequest(ProgressMethod, progressNotice{
+		ID:      c.Request.wireID(),
+		Percent: percent,
+		Message: message,
+		Data:    data,
+	})
+}
+
+// 进度交给对应请求的CallOpt.OnProgress
+func (c *Client) handleProgress(resp Response) {
+	var notice struct {
+		ID int64 `json:"id"`
+		Progress
+	}
+	if resp.Result == nil || json.Unmarshal(*resp.Result, &notice) != nil {
+		c.logger.Log(LevelWarn, "invalid progress notification")
+		return
+	}
+	c.mu.Lock()
+	call := c.pending[notice.ID]
+	c.mu.Unlock()
+	if call == nil || call.onProgress == nil {
+		c.logger.Log(LevelDebug, "ignoring progress with no callback", fieldRequest(notice.ID))
+		return
+	}
+	call.onProgress(notice.Progress)
+}
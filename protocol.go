@@ -0,0 +1,289 @@
+package win
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// 消息协议，所有协议共用同样的处理函数
+type Protocol int
+
+const (
+	// 默认协议，即Request/Response的JSON格式
+	ProtocolWin Protocol = iota
+	// 严格的JSON-RPC 2.0
+	ProtocolJSONRPC
+)
+
+// JSON-RPC 2.0标准错误码
+const (
+	jsonrpcParseError     = -32700
+	jsonrpcInvalidRequest = -32600
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+	jsonrpcInternalError  = -32603
+)
+
+// 协议的线路格式，负责Request/Response和JSON文本之间的转换
+type messageFormat interface {
+	// 解析一条消息，结果是请求（含通知）或响应之一，
+	// 无法处理时返回*Error，其中的错误码可以直接回复给对端，能识别出id时同时返回带id的请求
+	decode(data []byte) (*Request, *Response, error)
+	encodeRequest(req *Request) ([]byte, error)
+	encodeResponse(resp *Response) ([]byte, error)
+	parseError(err error) *Error
+	invalidRequest(reason string) *Error
+}
+
+func (p Protocol) format() messageFormat {
+	if p == ProtocolJSONRPC {
+		return jsonrpcFormat{}
+	}
+	return winFormat{}
+}
+
+type winFormat struct{}
+
+func (winFormat) decode(data []byte) (*Request, *Response, error) {
+	var msg struct {
+		Method  string                 `json:"method"`
+		Params  *json.RawMessage       `json:"params"`
+		ID      int64                  `json:"id"`
+		Headers map[string]interface{} `json:"headers"`
+		Result  *json.RawMessage       `json:"result"`
+		Error   *Error                 `json:"error"`
+	}
+	// 预先赋值用来区分字段不存在和值为null
+	msg.Params = &json.RawMessage{}
+	msg.Result = &json.RawMessage{}
+
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, nil, winFormat{}.parseError(err)
+	}
+
+	if msg.Error != nil || msg.Result == nil || len(*msg.Result) > 0 {
+		resp := &Response{
+			Method:  msg.Method,
+			ID:      msg.ID,
+			Error:   msg.Error,
+			Headers: msg.Headers,
+		}
+		if msg.Result == nil {
+			resp.Result = &jsonNull
+		} else if len(*msg.Result) > 0 {
+			resp.Result = msg.Result
+		}
+		return nil, resp, nil
+	}
+
+	req := &Request{
+		Method:  msg.Method,
+		ID:      msg.ID,
+		Headers: msg.Headers,
+	}
+	if msg.Params == nil {
+		req.Params = &jsonNull
+	} else if len(*msg.Params) > 0 {
+		req.Params = msg.Params
+	}
+	return req, nil, nil
+}
+
+func (winFormat) parseError(err error) *Error {
+	return builtinError(CodeInvalidRequest, "parse error", err.Error())
+}
+
+func (winFormat) invalidRequest(reason string) *Error {
+	return builtinError(CodeInvalidRequest, "invalid request", reason)
+}
+
+func (winFormat) encodeRequest(req *Request) ([]byte, error) {
+	return json.Marshal(req)
+}
+
+func (winFormat) encodeResponse(resp *Response) ([]byte, error) {
+	return json.Marshal(resp)
+}
+
+type jsonrpcFormat struct{}
+
+type jsonrpcMessage struct {
+	Version string           `json:"jsonrpc"`
+	Method  string           `json:"method,omitempty"`
+	Params  *json.RawMessage `json:"params,omitempty"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError    `json:"error,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (f jsonrpcFormat) decode(data []byte) (*Request, *Response, error) {
+	msg := jsonrpcMessage{
+		Params: &json.RawMessage{},
+		ID:     &json.RawMessage{},
+		Result: &json.RawMessage{},
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, nil, f.parseError(err)
+	}
+
+	// id不存在时为nil，为null时是"null"
+	var rawID json.RawMessage
+	if msg.ID == nil {
+		rawID = jsonNull
+	} else if len(*msg.ID) > 0 {
+		rawID = *msg.ID
+	}
+	id, validID := parseJSONRPCID(rawID)
+
+	if !validID {
+		return nil, nil, f.invalidRequest("id must be a string, number or null")
+	}
+	// 无效的消息带着id返回，没有id时回复的id为null
+	var invalid *Request
+	if rawID != nil {
+		invalid = &Request{ID: id, rawID: rawID}
+	}
+	if msg.Version != "2.0" {
+		return invalid, nil, f.invalidRequest(`jsonrpc must be "2.0"`)
+	}
+
+	if msg.Method != "" {
+		req := &Request{Method: msg.Method, ID: id, rawID: rawID}
+		if msg.Params != nil && len(*msg.Params) > 0 {
+			if c := (*msg.Params)[0]; c != '{' && c != '[' {
+				return invalid, nil, f.invalidRequest("params must be an object or array")
+			}
+			req.Params = msg.Params
+		} else if msg.Params == nil {
+			req.Params = &jsonNull
+		}
+		return req, nil, nil
+	}
+
+	if msg.Error != nil || msg.Result == nil || len(*msg.Result) > 0 {
+		resp := &Response{ID: id, rawID: rawID}
+		if msg.Error != nil {
+			resp.Error = &Error{Code: msg.Error.Code, Message: msg.Error.Message, Data: msg.Error.Data}
+		} else if msg.Result == nil {
+			resp.Result = &jsonNull
+		} else {
+			resp.Result = msg.Result
+		}
+		return nil, resp, nil
+	}
+
+	return invalid, nil, f.invalidRequest("missing method, result or error")
+}
+
+// 语法错误是Parse error，合法JSON但结构不对是Invalid Request
+func (f jsonrpcFormat) parseError(err error) *Error {
+	if _, ok := err.(*json.SyntaxError); ok {
+		return &Error{Code: jsonrpcParseError, Message: "Parse error", Data: err.Error()}
+	}
+	return f.invalidRequest(err.Error())
+}
+
+func (jsonrpcFormat) invalidRequest(reason string) *Error {
+	return &Error{Code: jsonrpcInvalidRequest, Message: "Invalid Request", Data: reason}
+}
+
+// 数字id同时解析到int64，字符串和null只保留原始值
+func parseJSONRPCID(raw json.RawMessage) (int64, bool) {
+	if raw == nil || bytes.Equal(raw, jsonNull) {
+		return 0, true
+	}
+	switch c := raw[0]; {
+	case c == '"':
+		return 0, true
+	case c == '-' || (c >= '0' && c <= '9'):
+		id, _ := strconv.ParseInt(string(raw), 10, 64)
+		return id, true
+	}
+	return 0, false
+}
+
+func (jsonrpcFormat) encodeRequest(req *Request) ([]byte, error) {
+	msg := jsonrpcMessage{
+		Version: "2.0",
+		Method:  req.Method,
+		ID:      req.wireID(),
+	}
+	if req.Params != nil && !bytes.Equal(*req.Params, jsonNull) {
+		msg.Params = req.Params
+	}
+	return json.Marshal(msg)
+}
+
+func (jsonrpcFormat) encodeResponse(resp *Response) ([]byte, error) {
+	// 没有id的推送消息按JSON-RPC通知发送
+	if resp.ID == 0 && resp.rawID == nil && resp.Method != "" && resp.Error == nil {
+		return json.Marshal(jsonrpcMessage{
+			Version: "2.0",
+			Method:  resp.Method,
+			Params:  jsonrpcParams(resp.Result),
+		})
+	}
+
+	msg := jsonrpcMessage{
+		Version: "2.0",
+		ID:      resp.wireID(),
+	}
+	if msg.ID == nil {
+		msg.ID = &jsonNull
+	}
+	if resp.Error != nil {
+		msg.Error = &jsonrpcError{
+			Code:    jsonrpcCode(resp.Error),
+			Message: resp.Error.Message,
+			Data:    resp.Error.Data,
+		}
+	} else if resp.Result == nil || len(*resp.Result) == 0 {
+		return nil, errors.New("can't marshal *win.Response (must have result or error)")
+	} else {
+		msg.Result = resp.Result
+	}
+	return json.Marshal(msg)
+}
+
+// JSON-RPC的params只能是对象或数组，其他值包装为只有一个元素的数组，null不发送params
+func jsonrpcParams(data *json.RawMessage) *json.RawMessage {
+	if data == nil {
+		return nil
+	}
+	v := bytes.TrimSpace(*data)
+	if len(v) == 0 || bytes.Equal(v, jsonNull) {
+		return nil
+	}
+	if v[0] == '{' || v[0] == '[' {
+		return data
+	}
+	wrapped := make(json.RawMessage, 0, len(v)+2)
+	wrapped = append(append(append(wrapped, '['), v...), ']')
+	return &wrapped
+}
+
+// 框架生成的错误映射为JSON-RPC标准错误码，处理函数的错误码原样返回
+func jsonrpcCode(e *Error) int {
+	if !e.builtin {
+		return e.Code
+	}
+	switch e.Code {
+	case CodeInvalidRequest:
+		return jsonrpcInvalidRequest
+	case CodeNotFound:
+		return jsonrpcMethodNotFound
+	case CodeInvalidParams:
+		return jsonrpcInvalidParams
+	case CodeInternalError:
+		return jsonrpcInternalError
+	}
+	return e.Code
+}
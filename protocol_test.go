@@ -0,0 +1,226 @@
+package win
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestJSONRPCRequestID(t *testing.T) {
+	tests := []struct {
+		msg          string
+		id           int64
+		wireID       string
+		notification bool
+	}{
+		{`{"jsonrpc":"2.0","method":"m","id":7}`, 7, `7`, false},
+		{`{"jsonrpc":"2.0","method":"m","id":-3}`, -3, `-3`, false},
+		{`{"jsonrpc":"2.0","method":"m","id":"abc"}`, 0, `"abc"`, false},
+		{`{"jsonrpc":"2.0","method":"m","id":"7"}`, 0, `"7"`, false},
+		{`{"jsonrpc":"2.0","method":"m","id":1.5}`, 0, `1.5`, false},
+		{`{"jsonrpc":"2.0","method":"m","id":null}`, 0, `null`, false},
+		{`{"jsonrpc":"2.0","method":"m"}`, 0, ``, true},
+	}
+	for _, tt := range tests {
+		req, resp, err := jsonrpcFormat{}.decode([]byte(tt.msg))
+		if err != nil || resp != nil || req == nil {
+			t.Errorf("%s: req %v, resp %v, err %v", tt.msg, req, resp, err)
+			continue
+		}
+		if req.ID != tt.id {
+			t.Errorf("%s: id %d, want %d", tt.msg, req.ID, tt.id)
+		}
+		if got := string(req.rawID); got != tt.wireID {
+			t.Errorf("%s: raw id %s, want %s", tt.msg, got, tt.wireID)
+		}
+		if req.IsNotification() != tt.notification {
+			t.Errorf("%s: notification %v, want %v", tt.msg, req.IsNotification(), tt.notification)
+		}
+	}
+}
+
+// 回复时原样带回请求的id，id为null的请求也要回复
+func TestJSONRPCResponseID(t *testing.T) {
+	for _, id := range []string{`7`, `"abc"`, `"7"`, `null`} {
+		req, _, err := jsonrpcFormat{}.decode([]byte(`{"jsonrpc":"2.0","method":"m","id":` + id + `}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := Response{ID: req.ID, rawID: req.rawID}
+		resp.setResult(true)
+		data, err := jsonrpcFormat{}.encodeResponse(&resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `{"jsonrpc":"2.0","id":` + id + `,"result":true}`
+		if string(data) != want {
+			t.Errorf("got %s, want %s", data, want)
+		}
+	}
+}
+
+func TestJSONRPCDecodeErrors(t *testing.T) {
+	tests := []struct {
+		msg  string
+		code int
+		id   string
+	}{
+		{`{"jsonrpc":"2.0","method":"m","id":1`, jsonrpcParseError, ``},
+		{`not json`, jsonrpcParseError, ``},
+		{`{"jsonrpc":"2.0","method":1,"id":1}`, jsonrpcInvalidRequest, ``},
+		{`"just a string"`, jsonrpcInvalidRequest, ``},
+		{`{"jsonrpc":"2.0","method":"m","id":{}}`, jsonrpcInvalidRequest, ``},
+		{`{"jsonrpc":"2.0","method":"m","id":true}`, jsonrpcInvalidRequest, ``},
+		{`{"method":"m","id":1}`, jsonrpcInvalidRequest, `1`},
+		{`{"jsonrpc":"1.0","method":"m","id":"a"}`, jsonrpcInvalidRequest, `"a"`},
+		{`{"jsonrpc":"2.0","method":"m","params":1,"id":2}`, jsonrpcInvalidRequest, `2`},
+		{`{"jsonrpc":"2.0","id":3}`, jsonrpcInvalidRequest, `3`},
+		{`{"jsonrpc":"2.0"}`, jsonrpcInvalidRequest, ``},
+	}
+	for _, tt := range tests {
+		req, _, err := jsonrpcFormat{}.decode([]byte(tt.msg))
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%s: err %v", tt.msg, err)
+			continue
+		}
+		if e.Code != tt.code {
+			t.Errorf("%s: code %d, want %d", tt.msg, e.Code, tt.code)
+		}
+		var id string
+		if req != nil {
+			id = string(req.rawID)
+		}
+		if id != tt.id {
+			t.Errorf("%s: id %s, want %s", tt.msg, id, tt.id)
+		}
+	}
+}
+
+// 推送按通知发送，params只能是对象或数组
+func TestJSONRPCNotifyParams(t *testing.T) {
+	tests := []struct {
+		data interface{}
+		want string
+	}{
+		{"hello", `{"jsonrpc":"2.0","method":"push","params":["hello"]}`},
+		{1.5, `{"jsonrpc":"2.0","method":"push","params":[1.5]}`},
+		{true, `{"jsonrpc":"2.0","method":"push","params":[true]}`},
+		{nil, `{"jsonrpc":"2.0","method":"push"}`},
+		{[]int{1, 2}, `{"jsonrpc":"2.0","method":"push","params":[1,2]}`},
+		{map[string]int{"a": 1}, `{"jsonrpc":"2.0","method":"push","params":{"a":1}}`},
+	}
+	for _, tt := range tests {
+		resp := Response{Method: "push"}
+		if err := resp.setResult(tt.data); err != nil {
+			t.Fatal(err)
+		}
+		data, err := jsonrpcFormat{}.encodeResponse(&resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%v: got %s, want %s", tt.data, data, tt.want)
+		}
+		if _, _, err := (jsonrpcFormat{}).decode(data); err != nil {
+			t.Errorf("%s: %v", data, err)
+		}
+	}
+}
+
+// 只有框架生成的错误映射为标准错误码，处理函数的错误码原样发送
+func TestJSONRPCErrorCode(t *testing.T) {
+	tests := []struct {
+		err  *Error
+		want int
+	}{
+		{builtinError(CodeInvalidRequest, ""), jsonrpcInvalidRequest},
+		{builtinError(CodeNotFound, ""), jsonrpcMethodNotFound},
+		{builtinError(CodeInvalidParams, ""), jsonrpcInvalidParams},
+		{builtinError(CodeInternalError, ""), jsonrpcInternalError},
+		{NewError(CodeInvalidRequest, ""), CodeInvalidRequest},
+		{NewError(CodeNotFound, ""), CodeNotFound},
+		{NewError(CodeInvalidParams, ""), CodeInvalidParams},
+		{NewError(CodeInternalError, ""), CodeInternalError},
+		{NewError(1001, ""), 1001},
+	}
+	for _, tt := range tests {
+		if got := jsonrpcCode(tt.err); got != tt.want {
+			t.Errorf("jsonrpcCode(%d, builtin %v) = %d, want %d", tt.err.Code, tt.err.builtin, got, tt.want)
+		}
+	}
+}
+
+// 批量请求中的通知不回复，全部是通知时不发送任何消息，处理函数的错误码不映射
+func TestJSONRPCBatch(t *testing.T) {
+	s := NewServer(WithProtocol(ProtocolJSONRPC))
+	s.AddHandler("echo", func(ctx Context) {
+		var v interface{}
+		ctx.BindJson(&v)
+		ctx.Reply(v)
+	})
+	s.AddHandler("lookup", func(ctx Context) {
+		ctx.ReplyError(CodeNotFound, "user not found")
+	})
+	ts := httptest.NewServer(http.HandlerFunc(s.Serve))
+	defer ts.Close()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	read := func() []map[string]interface{} {
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var items []map[string]interface{}
+		if err := json.Unmarshal(data, &items); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		return items
+	}
+
+	ws.WriteMessage(websocket.TextMessage, []byte(`[
+		{"jsonrpc":"2.0","method":"echo","params":[1],"id":1},
+		{"jsonrpc":"2.0","method":"echo","params":[2]},
+		{"jsonrpc":"2.0","method":"missing"},
+		{"jsonrpc":"2.0","method":"missing","id":"b"},
+		{"jsonrpc":"2.0","method":"lookup","id":"c"},
+		1
+	]`))
+	items := read()
+	if len(items) != 4 {
+		t.Fatalf("got %d responses, want 4: %v", len(items), items)
+	}
+	codes := map[string]float64{}
+	for _, item := range items {
+		id, _ := json.Marshal(item["id"])
+		if e, ok := item["error"].(map[string]interface{}); ok {
+			codes[string(id)] = e["code"].(float64)
+		} else {
+			codes[string(id)] = 0
+		}
+	}
+	want := map[string]float64{`1`: 0, `"b"`: jsonrpcMethodNotFound, `"c"`: CodeNotFound, `null`: jsonrpcInvalidRequest}
+	for id, code := range want {
+		if got, ok := codes[id]; !ok || got != code {
+			t.Errorf("id %s: code %v, want %v", id, got, code)
+		}
+	}
+
+	// 全部是通知时没有响应，下一条消息是之后请求的响应
+	ws.WriteMessage(websocket.TextMessage, []byte(`[{"jsonrpc":"2.0","method":"echo","params":[1]},{"jsonrpc":"2.0","method":"missing"}]`))
+	ws.WriteMessage(websocket.TextMessage, []byte(`[{"jsonrpc":"2.0","method":"echo","params":[3],"id":3}]`))
+	items = read()
+	if len(items) != 1 || items[0]["id"] != float64(3) {
+		t.Errorf("got %v, want response to id 3", items)
+	}
+}
@@ -0,0 +1,70 @@
+package win
+
+// 向所有连接推送，规则同BroadcastRoom
+func (s *Server) Broadcast(method string, data interface{}, exclude ...*Conn) (int, error) {
+	return s.push(s.Conns(), method, data, exclude...)
+}
+
+// 向满足predicate的连接推送，规则同BroadcastRoom，predicate执行时不持有锁
+func (s *Server) NotifyWhere(predicate func(conn *Conn) bool, method string, data interface{}) (int, error) {
+	var conns []*Conn
+	s.Range(func(conn *Conn) bool {
+		if predicate(conn) {
+			conns = append(conns, conn)
+		}
+		return true
+	})
+	return s.push(conns, method, data)
+}
+
+// 向指定ID的连接推送，按OverflowPolicy等待发送队列
+func (s *Server) NotifyConn(id uint32, method string, data interface{}) error {
+	conn := s.Conn(id)
+	if conn == nil {
+		return ErrConnNotFound
+	}
+	return conn.Notify(method, data)
+}
+
+// 推送消息，不对应任何请求，JSON-RPC模式下不是对象或数组的数据包装为[data]
+func (c *Conn) Notify(method string, data interface{}) error {
+	resp := Response{Method: method}
+	if err := resp.setResult(data); err != nil {
+		return err
+	}
+	return c.SendMessage(resp)
+}
+
+// 推送给一组连接，不持有任何锁
+func (s *Server) push(conns []*Conn, method string, data interface{}, exclude ...*Conn) (int, error) {
+	resp := Response{Method: method}
+	if err := resp.setResult(data); err != nil {
+		return 0, err
+	}
+	msg, err := s.config.protocol.format().encodeResponse(&resp)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, conn := range conns {
+		if isExcluded(conn, exclude) {
+			continue
+		}
+		if err := conn.tryEnqueue(msg); err != nil {
+			s.Logger().Log(LevelDebug, "push failed", fieldConn(conn.id), fieldMethod(method), fieldError(err))
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+func isExcluded(conn *Conn, exclude []*Conn) bool {
+	for _, c := range exclude {
+		if c == conn {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package win
+
+import (
+	"errors"
+)
+
+var ErrAlreadyReplied = errors.New("win: request already replied")
+
+// 处理函数返回时还没有回复的请求如何处理
+type NoReplyPolicy int
+
+const (
+	// 回复CodeInternalError错误"no response"
+	NoReplyError NoReplyPolicy = iota
+	// 回复null结果
+	NoReplyNull
+	// 不回复，所有处理函数都异步回复时使用，单个请求可以用Context.Async
+	NoReplyNone
+)
+
+// 标记已回复，已经回复过时返回false
+func (c *Context) markReplied() bool {
+	st := c.state
+	if st == nil {
+		return true
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.replied {
+		return false
+	}
+	st.replied = true
+	return true
+}
+
+// 是否已经回复过当前请求
+func (c *Context) Replied() bool {
+	if c.state == nil {
+		return false
+	}
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	return c.state.replied
+}
+
+// 当前请求在处理函数返回后由其他goroutine回复，例如异步的Stream和Progress。
+// 处理函数返回时不按NoReplyPolicy回复，请求的context保持有效，客户端仍然可以取消，
+// Shutdown也会等待回复。回复、取消、超时或连接关闭后请求结束，超时没有回复时按NoReplyPolicy回复
+func (c *Context) Async() {
+	if c.state == nil {
+		return
+	}
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	if c.state.async || c.state.replied {
+		return
+	}
+	c.state.async = true
+	c.state.refs++
+}
+
+func (c *Context) isAsync() bool {
+	if c.state == nil {
+		return false
+	}
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	return c.state.async
+}
+
+// 释放一个引用，都释放后请求结束
+func (c *Context) unref() {
+	c.state.mu.Lock()
+	c.state.refs--
+	n := c.state.refs
+	c.state.mu.Unlock()
+	if n == 0 {
+		c.finish()
+	}
+}
+
+// 处理函数链返回后调用，异步请求等到回复或context结束
+func (c *Context) handlerReturned() {
+	if c.state == nil {
+		return
+	}
+	if !c.isAsync() {
+		c.fallbackReply()
+		c.unref()
+		return
+	}
+	c.unref()
+	go func() {
+		<-c.state.ctx.Done()
+		if !c.Conn.isClosing() {
+			c.fallbackReply()
+		}
+		c.finish()
+	}()
+}
+
+// 需要回复但没有回复的请求按NoReplyPolicy回复
+func (c *Context) fallbackReply() {
+	if c.Request.IsNotification() || c.state.isCanceled() || c.Replied() {
+		return
+	}
+	switch c.Conn.Server.config.noReply {
+	case NoReplyError:
+		c.Conn.logger().Log(LevelWarn, "handler returned without reply", c.logFields()...)
+		c.replyError(builtinError(CodeInternalError, "no response"))
+	case NoReplyNull:
+		c.Reply(nil)
+	}
+}
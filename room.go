@@ -0,0 +1,74 @@
+package win
+
+// 把连接加入房间，已关闭的连接不会加入
+func (s *Server) Join(conn *Conn, room string) {
+	s.roomsMu.Lock()
+	defer s.roomsMu.Unlock()
+	if conn.isClosing() {
+		return
+	}
+	members, ok := s.rooms[room]
+	if !ok {
+		members = make(map[*Conn]struct{})
+		s.rooms[room] = members
+	}
+	members[conn] = struct{}{}
+	if conn.rooms == nil {
+		conn.rooms = make(map[string]struct{})
+	}
+	conn.rooms[room] = struct{}{}
+}
+
+// 把连接移出房间，房间没有成员后删除
+func (s *Server) Leave(conn *Conn, room string) {
+	s.roomsMu.Lock()
+	defer s.roomsMu.Unlock()
+	s.leave(conn, room)
+}
+
+func (s *Server) leave(conn *Conn, room string) {
+	if members, ok := s.rooms[room]; ok {
+		delete(members, conn)
+		if len(members) == 0 {
+			delete(s.rooms, room)
+		}
+	}
+	delete(conn.rooms, room)
+}
+
+// 连接关闭时移出所有房间
+func (s *Server) leaveAll(conn *Conn) {
+	s.roomsMu.Lock()
+	defer s.roomsMu.Unlock()
+	for room := range conn.rooms {
+		s.leave(conn, room)
+	}
+}
+
+// 房间当前成员的快照
+func (s *Server) Members(room string) []*Conn {
+	s.roomsMu.RLock()
+	defer s.roomsMu.RUnlock()
+	members := make([]*Conn, 0, len(s.rooms[room]))
+	for conn := range s.rooms[room] {
+		members = append(members, conn)
+	}
+	return members
+}
+
+// 连接所在的房间
+func (c *Conn) Rooms() []string {
+	c.Server.roomsMu.RLock()
+	defer c.Server.roomsMu.RUnlock()
+	rooms := make([]string, 0, len(c.rooms))
+	for room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// 向房间内除exclude外的所有成员推送，消息只编码一次。
+// 不等待发送队列已满的成员，这些成员按OverflowPolicy丢弃消息或断开，返回成功放入队列的数量
+func (s *Server) BroadcastRoom(room, method string, data interface{}, exclude ...*Conn) (int, error) {
+	return s.push(s.Members(room), method, data, exclude...)
+}
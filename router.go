@@ -0,0 +1,117 @@
+package win
+
+import (
+	"strings"
+)
+
+// 路由参数
+type Param struct {
+	Key   string
+	Value string
+}
+
+// 按"/"分段的路由树，支持命名参数(:name)和通配(*name)，
+// 匹配优先级：静态段 > 命名参数 > 通配
+type router struct {
+	root *node
+}
+
+type node struct {
+	static   map[string]*node
+	param    *node
+	catchAll *node
+	// 命名参数或通配的参数名
+	name    string
+	handler HandlerFunc
+	pattern string
+}
+
+func newRouter() *router {
+	return &router{root: &node{}}
+}
+
+func (r *router) add(pattern string, h HandlerFunc) {
+	segments := strings.Split(pattern, "/")
+	n := r.root
+	for i, seg := range segments {
+		switch {
+		case strings.HasPrefix(seg, ":"):
+			name := seg[1:]
+			if name == "" {
+				panic("Empty param name in handler name: " + pattern)
+			}
+			if n.param == nil {
+				n.param = &node{name: name}
+			} else if n.param.name != name {
+				panic("Conflicting param name :" + name + " with :" + n.param.name + " in handler name: " + pattern)
+			}
+			n = n.param
+		case strings.HasPrefix(seg, "*"):
+			name := seg[1:]
+			if name == "" {
+				panic("Empty catch-all name in handler name: " + pattern)
+			}
+			if i != len(segments)-1 {
+				panic("Catch-all must be the last segment in handler name: " + pattern)
+			}
+			if n.catchAll == nil {
+				n.catchAll = &node{name: name}
+			} else if n.catchAll.name != name {
+				panic("Conflicting catch-all *" + name + " with *" + n.catchAll.name + " in handler name: " + pattern)
+			}
+			n = n.catchAll
+		default:
+			if n.static == nil {
+				n.static = make(map[string]*node)
+			}
+			child, ok := n.static[seg]
+			if !ok {
+				child = &node{}
+				n.static[seg] = child
+			}
+			n = child
+		}
+	}
+	if n.handler != nil {
+		panic("Repeated handler name: " + pattern)
+	}
+	n.handler = h
+	n.pattern = pattern
+}
+
+func (r *router) find(method string) (HandlerFunc, []Param, bool) {
+	segments := strings.Split(method, "/")
+	n, params := r.root.match(segments, nil)
+	if n == nil {
+		return nil, nil, false
+	}
+	return n.handler, params, true
+}
+
+func (n *node) match(segments []string, params []Param) (*node, []Param) {
+	if len(segments) == 0 {
+		if n.handler != nil {
+			return n, params
+		}
+		// /files也匹配/files/*path，path为空
+		if n.catchAll != nil && n.catchAll.handler != nil {
+			return n.catchAll, append(params, Param{Key: n.catchAll.name})
+		}
+		return nil, nil
+	}
+	seg := segments[0]
+	if child, ok := n.static[seg]; ok {
+		if found, p := child.match(segments[1:], params); found != nil {
+			return found, p
+		}
+	}
+	if n.param != nil && seg != "" {
+		if found, p := n.param.match(segments[1:], append(params, Param{Key: n.param.name, Value: seg})); found != nil {
+			return found, p
+		}
+	}
+	if n.catchAll != nil && n.catchAll.handler != nil {
+		return n.catchAll, append(params, Param{Key: n.catchAll.name, Value: strings.Join(segments, "/")})
+	}
+	return nil, nil
+}
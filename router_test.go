@@ -0,0 +1,101 @@
+package win
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRouterFind(t *testing.T) {
+	r := newRouter()
+	for _, pattern := range []string{
+		"hello",
+		"/room/list",
+		"/room/:roomId",
+		"/room/:roomId/send",
+		"/room/:roomId/*rest",
+		"/files/*path",
+		"/files/static/logo",
+		"/user/:id/profile",
+		"/user/admin/settings",
+	} {
+		pattern := pattern
+		r.add(pattern, func(ctx Context) { ctx.Reply(pattern) })
+	}
+
+	tests := []struct {
+		method  string
+		pattern string
+		params  []Param
+	}{
+		{"hello", "hello", nil},
+		{"hello/", "", nil},
+		{"nothing", "", nil},
+		// 静态段优先于命名参数
+		{"/room/list", "/room/list", nil},
+		{"/room/1", "/room/:roomId", []Param{{"roomId", "1"}}},
+		{"/room/1/send", "/room/:roomId/send", []Param{{"roomId", "1"}}},
+		// 命名参数优先于通配
+		{"/room/1/other", "/room/:roomId/*rest", []Param{{"roomId", "1"}, {"rest", "other"}}},
+		{"/room/list/send", "/room/:roomId/send", []Param{{"roomId", "list"}}},
+		{"/room/", "", nil},
+		{"/files/a/b.txt", "/files/*path", []Param{{"path", "a/b.txt"}}},
+		{"/files/static/logo", "/files/static/logo", nil},
+		{"/files/static/other", "/files/*path", []Param{{"path", "static/other"}}},
+		{"/files/", "/files/*path", []Param{{"path", ""}}},
+		{"/files", "/files/*path", []Param{{"path", ""}}},
+		// 静态段后面没有匹配时回退到命名参数
+		{"/user/admin/profile", "/user/:id/profile", []Param{{"id", "admin"}}},
+		{"/user/admin/settings", "/user/admin/settings", nil},
+		{"/user/1/settings", "", nil},
+	}
+	for _, tt := range tests {
+		h, params, ok := r.find(tt.method)
+		if tt.pattern == "" {
+			if ok {
+				t.Errorf("%s: unexpected match", tt.method)
+			}
+			continue
+		}
+		if !ok || h == nil {
+			t.Errorf("%s: not found, want %s", tt.method, tt.pattern)
+			continue
+		}
+		n, _ := r.root.match(strings.Split(tt.method, "/"), nil)
+		if n.pattern != tt.pattern {
+			t.Errorf("%s: matched %s, want %s", tt.method, n.pattern, tt.pattern)
+		}
+		if !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("%s: params %v, want %v", tt.method, params, tt.params)
+		}
+	}
+}
+
+func TestRouterAddPanics(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		panic    string
+	}{
+		{[]string{"a", "a"}, "Repeated handler name: a"},
+		{[]string{"/room/:id", "/room/:id"}, "Repeated handler name: /room/:id"},
+		{[]string{"/room/:"}, "Empty param name in handler name: /room/:"},
+		{[]string{"/files/*"}, "Empty catch-all name in handler name: /files/*"},
+		{[]string{"/files/*path/more"}, "Catch-all must be the last segment in handler name: /files/*path/more"},
+		{[]string{"/room/:id", "/room/:name/send"}, "Conflicting param name :name with :id in handler name: /room/:name/send"},
+		{[]string{"/files/*path", "/files/*rest"}, "Conflicting catch-all *rest with *path in handler name: /files/*rest"},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				r := recover()
+				if r != tt.panic {
+					t.Errorf("%v: panic %v, want %q", tt.patterns, r, tt.panic)
+				}
+			}()
+			r := newRouter()
+			for _, p := range tt.patterns {
+				r.add(p, func(ctx Context) {})
+			}
+		}()
+	}
+}
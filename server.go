@@ -1,13 +1,15 @@
 package win
 
 import (
+	"context"
 	"github.com/gorilla/websocket"
-	"log"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Server struct {
@@ -19,63 +21,191 @@ type Server struct {
 	connStartCallback func(conn *Conn)
 	connCloseCallback func(conn *Conn)
 	handshakeHandler  func(r *http.Request) bool
+	authHandler       AuthFunc
 	notFoundHandler   HandlerFunc
+	inShutdown        int32
+	config            serverConfig
+	roomsMu           sync.RWMutex
+	rooms             map[string]map[*Conn]struct{}
+	usersMu           sync.RWMutex
+	users             map[string]map[*Conn]struct{}
 }
 
-func NewServer() *Server {
+func NewServer(opts ...ServerOption) *Server {
+	cfg := config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	s := &Server{
 		clients:    make(map[uint32]*Conn),
-		msgHandler: newMsgHandler(),
-		upgrader: newUpgrader(config.allowedOrigins, func(r *http.Request) bool {
-			return true
-		}),
-		connId: 0,
+		rooms:      make(map[string]map[*Conn]struct{}),
+		users:      make(map[string]map[*Conn]struct{}),
+		msgHandler: newMsgHandler(cfg.workerPoolSize, cfg.workerTaskMax, cfg.logger),
+		connId:     0,
+		config:     cfg,
 	}
+	s.upgrader = newUpgrader(cfg, s.checkHandshake)
 
-	s.msgHandler.notFoundHandler = s.notFoundHandler
-
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 	// 开启Workers
-	if config.workerPoolSize > 0 {
-		go s.msgHandler.startWorkerPool()
+	if s.config.workerPoolSize > 0 {
+		s.msgHandler.startWorkerPool()
 	}
 	return s
 }
 
 func (s *Server) Serve(w http.ResponseWriter, r *http.Request) {
-	c, err := s.upgrader.Upgrade(w, r, nil)
+	if s.shuttingDown() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	identity, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	// 客户端没有提供我们支持的子协议时不返回子协议，否则客户端会断开连接
+	codec, negotiated := negotiateCodec(websocket.Subprotocols(r), s.config.codecs)
+	var header http.Header
+	if negotiated {
+		header = http.Header{"Sec-Websocket-Protocol": {codec.Name()}}
+	}
+	c, err := s.upgrader.Upgrade(w, r, header)
 	if err != nil {
-		log.Printf("[win-debug]: websocket upgrader err: %v", err)
+		s.Logger().Log(LevelWarn, "websocket upgrade failed", fieldError(err))
 		return
 	}
 
-	if len(s.clients) > config.maxConn {
-		log.Printf("[win-debug]: max connections limit: %d", config.maxConn)
+	if s.config.readLimit > 0 {
+		c.SetReadLimit(s.config.readLimit)
+	}
+
+	if s.Len() > s.config.maxConn {
+		s.Logger().Log(LevelWarn, "max connections limit reached", F("max_conn", s.config.maxConn))
 		c.Close()
 		return
 	}
 
-	s.connId++
-	conn := newConn(s, s.connId, c, s.msgHandler)
-	log.Printf("[win-debug]: new conn, id: %d", s.connId)
+	id := atomic.AddUint32(&s.connId, 1)
+	conn := newConn(s, id, c, s.msgHandler, newHandshake(r, s.config.trustedProxies), codec)
+	conn.identity = identity
+	if !s.register(id, conn) {
+		msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown")
+		c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		c.Close()
+		return
+	}
+	if user, ok := identity.(UserIdentity); ok && user.UserID() != "" {
+		s.BindUser(conn, user.UserID())
+	}
+	s.Logger().Log(LevelDebug, "new conn", fieldConn(id))
 
 	go conn.start()
 }
 
+// 立即关闭所有连接，不等待处理中的请求
 func (s *Server) Close() {
-	log.Printf("[win-debug]: Server Close")
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	for id, conn := range s.clients {
+	s.Logger().Log(LevelInfo, "server close")
+	for _, conn := range s.Conns() {
 		conn.Close()
-		delete(s.clients, id)
 	}
 }
 
-func (s *Server) register(id uint32, conn *Conn) {
+// 优雅关闭：拒绝新连接，停止读取新请求，等待处理中和排队中的请求完成并发送完响应，
+// 然后以GoingAway关闭帧关闭连接。ctx到期时强制关闭剩余连接并返回ctx.Err()
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Logger().Log(LevelInfo, "server shutdown")
+	atomic.StoreInt32(&s.inShutdown, 1)
+	defer s.msgHandler.stopWorkerPool()
+
+	conns := s.Conns()
+	for _, conn := range conns {
+		conn.stopRead()
+	}
+
+	// 读goroutine全部退出后不会再有新的请求进入
+	for _, conn := range conns {
+		select {
+		case <-conn.readDone:
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.msgHandler.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+
+	for _, conn := range conns {
+		if err := conn.drain(ctx); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	for _, conn := range conns {
+		select {
+		case <-conn.exitChan:
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *Server) Logger() Logger {
+	return s.config.logger
+}
+
+func (s *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&s.inShutdown) != 0
+}
+
+func (s *Server) register(id uint32, conn *Conn) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.shuttingDown() {
+		return false
+	}
 	s.clients[id] = conn
+	return true
+}
+
+// 当前连接的快照，可以在不持有锁的情况下遍历和发送
+func (s *Server) Conns() []*Conn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	conns := make([]*Conn, 0, len(s.clients))
+	for _, conn := range s.clients {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// 遍历当前连接的快照，f返回false时停止，f中可以发送消息或关闭连接
+func (s *Server) Range(f func(conn *Conn) bool) {
+	for _, conn := range s.Conns() {
+		if !f(conn) {
+			return
+		}
+	}
+}
+
+// 按连接ID查找，连接不存在时返回nil
+func (s *Server) Conn(id uint32) *Conn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.clients[id]
 }
 
 func (s *Server) remove(id uint32) {
@@ -85,6 +215,8 @@ func (s *Server) remove(id uint32) {
 }
 
 func (s *Server) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return len(s.clients)
 }
 
@@ -92,10 +224,25 @@ func (s *Server) Use(middleware ...MiddlewareFunc) {
 	s.msgHandler.use(middleware...)
 }
 
+// 对所有请求的响应生效的拦截器，按注册顺序执行，见ResponseInterceptor
+func (s *Server) UseInterceptor(interceptors ...ResponseInterceptor) {
+	s.msgHandler.interceptors = append(s.msgHandler.interceptors, interceptors...)
+}
+
 func (s *Server) AddHandler(name string, h HandlerFunc, middleware ...MiddlewareFunc) {
 	s.msgHandler.handlerFunc(name, h, middleware...)
 }
 
+// 注册返回error的处理函数，见HandlerE
+func (s *Server) AddHandlerE(name string, h HandlerFuncE, middleware ...MiddlewareFunc) {
+	s.AddHandler(name, HandlerE(h), middleware...)
+}
+
+// 注册带类型的处理函数，见Typed
+func (s *Server) AddTypedHandler(name string, fn interface{}, middleware ...MiddlewareFunc) {
+	s.AddHandler(name, Typed(fn), middleware...)
+}
+
 func (s *Server) Group(prefix string, middleware ...MiddlewareFunc) Group {
 	return newGroup(prefix, s, middleware...)
 }
@@ -112,8 +259,26 @@ func (s *Server) SetHandshakeHandler(handler func(r *http.Request) bool) {
 	s.handshakeHandler = handler
 }
 
+// 握手认证，认证通过的身份信息保存在Conn上
+func (s *Server) SetAuthHandler(handler AuthFunc) {
+	s.authHandler = handler
+}
+
+func (s *Server) checkHandshake(r *http.Request) bool {
+	if s.handshakeHandler == nil {
+		return true
+	}
+	return s.handshakeHandler(r)
+}
+
+// 统一把处理函数返回的错误和panic转换为响应，见ErrorHandler
+func (s *Server) SetErrorHandler(handler ErrorHandler) {
+	s.msgHandler.errorHandler = handler
+}
+
 func (s *Server) SetNotFoundHandler(handler HandlerFunc) {
 	s.notFoundHandler = handler
+	s.msgHandler.notFoundHandler = handler
 }
 
 func isAllowedOrigin(r *http.Request, allowedOrigins []*regexp.Regexp) bool {
@@ -140,11 +305,12 @@ func isAllowedOrigin(r *http.Request, allowedOrigins []*regexp.Regexp) bool {
 	return false
 }
 
-func newUpgrader(allowedWebSocketOrigins []string, handshakeHandler func(r *http.Request) bool) *websocket.Upgrader {
-	compiledAllowedOrigins := compileAllowedWebSocketOrigins(allowedWebSocketOrigins)
+func newUpgrader(cfg serverConfig, handshakeHandler func(r *http.Request) bool) *websocket.Upgrader {
+	compiledAllowedOrigins := compileAllowedWebSocketOrigins(cfg.allowedOrigins)
 	return &websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
+		HandshakeTimeout: cfg.handshakeTimeout,
+		ReadBufferSize:   cfg.readBufferSize,
+		WriteBufferSize:  cfg.writeBufferSize,
 		CheckOrigin: func(r *http.Request) bool {
 			return isAllowedOrigin(r, compiledAllowedOrigins) && handshakeHandler(r)
 		},
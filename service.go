@@ -0,0 +1,84 @@
+package win
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 注册服务时被跳过的方法及原因
+type SkippedMethod struct {
+	Name   string
+	Reason string
+}
+
+func (m SkippedMethod) String() string {
+	return m.Name + ": " + m.Reason
+}
+
+// 把rcvr的导出方法注册为处理函数，方法签名要求同Typed。
+// name不以"/"开头时路由为name.Method（如account.Login），以"/"开头时为路径形式（如/account/login），
+// name为空时使用rcvr的类型名。返回被跳过的方法及原因，没有任何方法可注册时返回错误
+func (s *Server) RegisterService(name string, rcvr interface{}, middleware ...MiddlewareFunc) ([]SkippedMethod, error) {
+	if rcvr == nil {
+		return nil, errors.New("win: RegisterService: rcvr is nil")
+	}
+	v := reflect.ValueOf(rcvr)
+	t := v.Type()
+	if name == "" {
+		name = reflect.Indirect(v).Type().Name()
+		if name == "" {
+			return nil, errors.New("win: RegisterService: no service name for type " + t.String())
+		}
+	}
+
+	var skipped []SkippedMethod
+	registered := 0
+	for i := 0; i < t.NumMethod(); i++ {
+		method := t.Method(i)
+		if method.PkgPath != "" {
+			continue
+		}
+		h, err := parseTypedHandler(v.Method(i))
+		if err != nil {
+			skipped = append(skipped, SkippedMethod{Name: method.Name, Reason: err.Error()})
+			continue
+		}
+		route := serviceRoute(name, method.Name)
+		if err := s.addServiceHandler(route, h.handle, middleware...); err != nil {
+			skipped = append(skipped, SkippedMethod{Name: method.Name, Reason: err.Error()})
+			continue
+		}
+		registered++
+	}
+
+	for _, m := range skipped {
+		s.Logger().Log(LevelWarn, "service method skipped", F("service", name), fieldMethod(m.Name), F("reason", m.Reason))
+	}
+	if registered == 0 {
+		return skipped, fmt.Errorf("win: RegisterService: type %s has no suitable methods", t.String())
+	}
+	return skipped, nil
+}
+
+// 注册失败（如重复的名字）时返回错误而不是panic
+func (s *Server) addServiceHandler(route string, h HandlerFunc, middleware ...MiddlewareFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	s.AddHandler(route, h, middleware...)
+	return nil
+}
+
+func serviceRoute(service, method string) string {
+	if !strings.HasPrefix(service, "/") {
+		return service + "." + method
+	}
+	r, size := utf8.DecodeRuneInString(method)
+	return strings.TrimSuffix(service, "/") + "/" + string(unicode.ToLower(r)) + method[size:]
+}
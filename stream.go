@@ -0,0 +1,180 @@
+package win
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// 保留的流式数据方法，参数为{"id": 请求id, "data": 数据}，
+// 之后请求的普通响应表示流结束或出错
+const StreamMethod = "$/stream"
+
+var errNotificationRequest = errors.New("win: request is a notification")
+
+type streamChunk struct {
+	ID   *json.RawMessage `json:"id"`
+	Data interface{}      `json:"data"`
+}
+
+// 服务端的流式响应，多次Send后用End或Error结束
+type Stream struct {
+	ctx Context
+}
+
+// 以流的方式回复当前请求，在处理函数返回后继续发送时先调用Context.Async
+func (c *Context) Stream() *Stream {
+	return &Stream{ctx: *c}
+}
+
+// 发送一块数据，带上请求id以便客户端对应到请求
+func (s *Stream) Send(data interface{}) error {
+	return s.ctx.notifyRequest(StreamMethod, streamChunk{ID: s.ctx.Request.wireID(), Data: data})
+}
+
+// 发送关联到当前请求的通知，通知和已取消的请求不发送
+func (c *Context) notifyRequest(method string, params interface{}) error {
+	if c.Request.IsNotification() {
+		return errNotificationRequest
+	}
+	if c.state.isCanceled() {
+		return ErrRequestCanceled
+	}
+	resp := Response{Method: method}
+	if err := resp.setResult(params); err != nil {
+		return err
+	}
+	c.intercept(&resp)
+	return c.sendMessage(resp)
+}
+
+// 正常结束
+func (s *Stream) End() error {
+	return s.ctx.Reply(nil)
+}
+
+// 出错结束
+func (s *Stream) Error(code int, msg string, data ...interface{}) error {
+	return s.ctx.ReplyError(code, msg, data...)
+}
+
+// 客户端的流式请求，用法和sql.Rows类似：
+//
+//	for s.Next() { s.Decode(&v) }
+//	err := s.Err()
+type ClientStream struct {
+	client *Client
+	call   *call
+	ctx    context.Context
+
+	mu     sync.Mutex
+	chunks []json.RawMessage
+	signal chan struct{}
+
+	current  json.RawMessage
+	finished bool
+	err      error
+}
+
+// 发起流式请求，ctx取消或调用Close时通知服务端取消
+func (c *Client) Stream(ctx context.Context, method string, params interface{}, opts ...*CallOpt) (*ClientStream, error) {
+	req := Request{
+		Method: method,
+	}
+	if err := req.SetParams(params); err != nil {
+		return nil, err
+	}
+	var opt *CallOpt
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt != nil && len(opt.Headers) > 0 {
+		req.SetHeaders(opt.Headers)
+	}
+
+	s := &ClientStream{
+		client: c,
+		ctx:    ctx,
+		signal: make(chan struct{}, 1),
+	}
+	calls, err := c.send([]*Request{&req}, []bool{true}, false, func(cc *call) {
+		cc.stream = s
+		opt.attach(cc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.call = calls[0]
+	return s, nil
+}
+
+// 读goroutine收到数据块
+func (s *ClientStream) push(data json.RawMessage) {
+	s.mu.Lock()
+	s.chunks = append(s.chunks, data)
+	s.mu.Unlock()
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+// 等待下一块数据，流结束、出错或取消时返回false
+func (s *ClientStream) Next() bool {
+	for {
+		s.mu.Lock()
+		if len(s.chunks) > 0 {
+			s.current = s.chunks[0]
+			s.chunks = s.chunks[1:]
+			s.mu.Unlock()
+			return true
+		}
+		s.mu.Unlock()
+		if s.finished {
+			s.current = nil
+			return false
+		}
+
+		select {
+		case <-s.signal:
+		case err, ok := <-s.call.done:
+			// 数据块都在响应之前到达，继续循环取完剩余的数据块
+			s.finished = true
+			if !ok {
+				err = errors.New("conn has closed")
+			}
+			s.err = err
+		case <-s.ctx.Done():
+			s.client.cancel(s.call)
+			s.finished = true
+			s.err = s.ctx.Err()
+			s.mu.Lock()
+			s.chunks = nil
+			s.mu.Unlock()
+		}
+	}
+}
+
+// 把当前数据块解析到v
+func (s *ClientStream) Decode(v interface{}) error {
+	if s.current == nil {
+		return errors.New("win: no current chunk")
+	}
+	return json.Unmarshal(s.current, v)
+}
+
+// 流结束后的错误，正常结束时为nil
+func (s *ClientStream) Err() error {
+	return s.err
+}
+
+// 提前结束，流还没结束时通知服务端取消
+func (s *ClientStream) Close() {
+	if s.finished {
+		return
+	}
+	s.client.cancel(s.call)
+	s.finished = true
+	s.err = context.Canceled
+}
@@ -0,0 +1,95 @@
+package win
+
+import (
+	"errors"
+	"reflect"
+)
+
+var (
+	contextType    = reflect.TypeOf(Context{})
+	contextPtrType = reflect.TypeOf(&Context{})
+	errorType      = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// 带类型的处理函数，形如func(ctx *Context, req *T) (R, error)
+type typedHandler struct {
+	fn      reflect.Value
+	ctxPtr  bool
+	reqType reflect.Type
+}
+
+// 检查函数签名，不符合时返回原因。ctx可以是*Context或Context，参数可以省略
+func parseTypedHandler(fn reflect.Value) (*typedHandler, error) {
+	t := fn.Type()
+	if t.Kind() != reflect.Func {
+		return nil, errors.New("not a function")
+	}
+	if t.NumIn() < 1 || t.NumIn() > 2 {
+		return nil, errors.New("must take (ctx *win.Context) or (ctx *win.Context, params)")
+	}
+	h := &typedHandler{fn: fn}
+	switch t.In(0) {
+	case contextPtrType:
+		h.ctxPtr = true
+	case contextType:
+	default:
+		return nil, errors.New("first argument must be *win.Context or win.Context")
+	}
+	if t.NumIn() == 2 {
+		h.reqType = t.In(1)
+		switch h.reqType.Kind() {
+		case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+			return nil, errors.New("params type " + h.reqType.String() + " can't be decoded")
+		}
+		if err := checkValidateTags(h.reqType); err != nil {
+			return nil, err
+		}
+	}
+	if t.NumOut() != 2 || t.Out(1) != errorType {
+		return nil, errors.New("must return (result, error)")
+	}
+	return h, nil
+}
+
+func (h *typedHandler) handle(ctx Context) {
+	args := make([]reflect.Value, 0, 2)
+	if h.ctxPtr {
+		args = append(args, reflect.ValueOf(&ctx))
+	} else {
+		args = append(args, reflect.ValueOf(ctx))
+	}
+	if h.reqType != nil {
+		var req reflect.Value
+		if h.reqType.Kind() == reflect.Ptr {
+			req = reflect.New(h.reqType.Elem())
+		} else {
+			req = reflect.New(h.reqType)
+		}
+		if err := ctx.bind(req.Interface(), ctx.Conn.Server.config.strictBind); err != nil {
+			ctx.handleError(err)
+			return
+		}
+		if h.reqType.Kind() != reflect.Ptr {
+			req = req.Elem()
+		}
+		args = append(args, req)
+	}
+
+	out := h.fn.Call(args)
+	if err, _ := out[1].Interface().(error); err != nil {
+		ctx.handleError(err)
+		return
+	}
+	ctx.Reply(out[0].Interface())
+}
+
+// 把形如func(ctx *Context, req *T) (R, error)的函数转换为HandlerFunc：
+// 参数解析到T并按validate标签校验，WithStrictBind时参数中有T没有的字段也是错误，
+// 解析失败或返回error时回复错误，否则把R作为结果回复。签名或validate标签不符合时panic
+func Typed(fn interface{}) HandlerFunc {
+	h, err := parseTypedHandler(reflect.ValueOf(fn))
+	if err != nil {
+		panic("win: invalid typed handler " + reflect.TypeOf(fn).String() + ": " + err.Error())
+	}
+	return h.handle
+}
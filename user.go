@@ -0,0 +1,85 @@
+package win
+
+// 握手认证得到的身份信息实现这个接口时，连接建立后自动绑定到UserID
+type UserIdentity interface {
+	UserID() string
+}
+
+// 把连接绑定到应用的用户，一个用户可以有多个连接，一个连接只属于一个用户，
+// 重复绑定时解除原来的绑定，已关闭的连接不会绑定
+func (s *Server) BindUser(conn *Conn, userID string) {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	if conn.isClosing() {
+		return
+	}
+	s.unbindUser(conn)
+	conns, ok := s.users[userID]
+	if !ok {
+		conns = make(map[*Conn]struct{})
+		s.users[userID] = conns
+	}
+	conns[conn] = struct{}{}
+	conn.userID = userID
+}
+
+// 解除连接和用户的绑定
+func (s *Server) UnbindUser(conn *Conn) {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	s.unbindUser(conn)
+}
+
+func (s *Server) unbindUser(conn *Conn) {
+	s.removeUserConn(conn)
+	conn.userID = ""
+}
+
+// 连接关闭时从用户的连接中移除，关闭回调中IsOnline已不包含这个连接，Conn.UserID仍可用
+func (s *Server) dropUser(conn *Conn) {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	s.removeUserConn(conn)
+}
+
+func (s *Server) removeUserConn(conn *Conn) {
+	if conn.userID == "" {
+		return
+	}
+	if conns, ok := s.users[conn.userID]; ok {
+		delete(conns, conn)
+		if len(conns) == 0 {
+			delete(s.users, conn.userID)
+		}
+	}
+}
+
+// 用户当前连接的快照
+func (s *Server) ConnsOfUser(userID string) []*Conn {
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+	conns := make([]*Conn, 0, len(s.users[userID]))
+	for conn := range s.users[userID] {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// 用户是否有连接在线
+func (s *Server) IsOnline(userID string) bool {
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+	return len(s.users[userID]) > 0
+}
+
+// 向用户的所有连接推送，规则同BroadcastRoom
+func (s *Server) SendToUser(userID, method string, data interface{}) (int, error) {
+	return s.push(s.ConnsOfUser(userID), method, data)
+}
+
+// 连接绑定的用户，没有绑定时为空字符串
+func (c *Conn) UserID() string {
+	c.Server.usersMu.RLock()
+	defer c.Server.usersMu.RUnlock()
+	return c.userID
+}
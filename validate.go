@@ -0,0 +1,366 @@
+package win
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// 参数校验失败的字段，Field为按json名称组成的路径，如items[0].name
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// 解析参数到ptr并按validate标签校验，失败时返回*Error，Data为[]FieldError
+func (c *Context) Bind(ptr interface{}) error {
+	return c.bind(ptr, false)
+}
+
+// 同Bind，参数中有结构体没有的字段时也返回错误
+func (c *Context) BindStrict(ptr interface{}) error {
+	return c.bind(ptr, true)
+}
+
+func (c *Context) bind(ptr interface{}, strict bool) error {
+	if c.Request.Params != nil {
+		dec := json.NewDecoder(bytes.NewReader(*c.Request.Params))
+		if strict {
+			dec.DisallowUnknownFields()
+		}
+		if err := dec.Decode(ptr); err != nil {
+			return builtinError(CodeInvalidParams, "invalid params", err.Error())
+		}
+	}
+	return Validate(ptr)
+}
+
+// 按validate标签校验结构体，规则用逗号分隔：
+//
+//	required       不能为零值，指针不能为nil，字符串、切片和map不能为空
+//	min=n, max=n   数字比较大小，字符串、切片和map比较长度
+//	len=n          字符串、切片和map的长度
+//	oneof=a b c    值为其中之一
+//	regexp=expr    字符串匹配正则，必须放在最后，expr中可以有逗号
+//
+// 嵌套的结构体和结构体切片也会校验。标签写错时panic，Typed和RegisterService在注册时检查
+func Validate(v interface{}) error {
+	var errs []FieldError
+	validateValue(reflect.ValueOf(v), "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return builtinError(CodeInvalidParams, "invalid params", errs)
+}
+
+func validateValue(v reflect.Value, path string, errs *[]FieldError) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		validateStruct(v, path, errs)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+func validateStruct(v reflect.Value, path string, errs *[]FieldError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name, ok := jsonFieldName(f)
+		if !ok {
+			continue
+		}
+		fv := v.Field(i)
+		// 匿名结构体的字段提升到外层
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			validateValue(fv, path, errs)
+			continue
+		}
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+		if tag := f.Tag.Get("validate"); tag != "" {
+			if reason := checkRules(fv, tag); reason != "" {
+				*errs = append(*errs, FieldError{Field: fieldPath, Reason: reason})
+				continue
+			}
+		}
+		validateValue(fv, fieldPath, errs)
+	}
+}
+
+func jsonFieldName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+	return f.Name, true
+}
+
+// 解析后的一条规则
+type validateRule struct {
+	name string
+	arg  string
+	n    float64
+	re   *regexp.Regexp
+}
+
+// 返回第一个不满足的规则的原因，都满足时返回空字符串
+func checkRules(v reflect.Value, tag string) string {
+	rules := mustParseRules(tag)
+	isNil := (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil()
+	for _, rule := range rules {
+		if rule.name == "required" {
+			if isNil || isEmpty(v) {
+				return "required"
+			}
+		}
+	}
+	// 没有required的nil指针不校验其他规则
+	if isNil {
+		return ""
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	for _, rule := range rules {
+		switch rule.name {
+		case "min", "max", "len":
+			// interface{}字段的值来自客户端，类型不符时作为校验失败
+			size, isLen, ok := measure(v.Kind(), v)
+			if !ok || (rule.name == "len" && !isLen) {
+				return mismatchReason(rule.name)
+			}
+			unit := ""
+			if isLen {
+				unit = "length "
+			}
+			switch {
+			case rule.name == "min" && size < rule.n:
+				return fmt.Sprintf("%smust be at least %s", unit, rule.arg)
+			case rule.name == "max" && size > rule.n:
+				return fmt.Sprintf("%smust be at most %s", unit, rule.arg)
+			case rule.name == "len" && size != rule.n:
+				return fmt.Sprintf("length must be %s", rule.arg)
+			}
+		case "oneof":
+			val := fmt.Sprint(v)
+			found := false
+			for _, option := range strings.Fields(rule.arg) {
+				if option == val {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return "must be one of [" + strings.Join(strings.Fields(rule.arg), " ") + "]"
+			}
+		case "regexp":
+			if v.Kind() != reflect.String {
+				return mismatchReason(rule.name)
+			}
+			if !rule.re.MatchString(v.String()) {
+				return "must match " + rule.arg
+			}
+		}
+	}
+	return ""
+}
+
+func mismatchReason(rule string) string {
+	switch rule {
+	case "len":
+		return "must be a string, array or object"
+	case "regexp":
+		return "must be a string"
+	}
+	return "must be a number, string, array or object"
+}
+
+// 规则能否用于kind类型的值，interface{}在运行时检查
+func ruleApplies(rule string, kind reflect.Kind) bool {
+	if kind == reflect.Interface {
+		return true
+	}
+	switch rule {
+	case "min", "max", "len":
+		_, isLen, ok := measure(kind, reflect.Value{})
+		return ok && (rule != "len" || isLen)
+	case "regexp":
+		return kind == reflect.String
+	}
+	return true
+}
+
+var parsedRules sync.Map
+
+// 解析标签，结果按标签缓存，标签写错时panic
+func mustParseRules(tag string) []validateRule {
+	rules, err := parseRules(tag)
+	if err != nil {
+		panic("win: " + err.Error())
+	}
+	return rules
+}
+
+func parseRules(tag string) ([]validateRule, error) {
+	if rules, ok := parsedRules.Load(tag); ok {
+		return rules.([]validateRule), nil
+	}
+	var rules []validateRule
+	for _, s := range splitRules(tag) {
+		rule := validateRule{name: s}
+		if i := strings.Index(s, "="); i >= 0 {
+			rule.name, rule.arg = s[:i], s[i+1:]
+		}
+		switch rule.name {
+		case "required", "oneof":
+		case "min", "max", "len":
+			n, err := strconv.ParseFloat(rule.arg, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid validate rule %s: %v", s, err)
+			}
+			rule.n = n
+		case "regexp":
+			re, err := regexp.Compile(rule.arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid validate rule %s: %v", s, err)
+			}
+			rule.re = re
+		default:
+			return nil, fmt.Errorf("unknown validate rule %s", s)
+		}
+		rules = append(rules, rule)
+	}
+	parsedRules.Store(tag, rules)
+	return rules, nil
+}
+
+// 检查类型中所有validate标签的写法和适用的字段类型，注册处理函数时调用
+func checkValidateTags(t reflect.Type) error {
+	return checkTypeTags(t, "", map[reflect.Type]bool{})
+}
+
+func checkTypeTags(t reflect.Type, path string, seen map[reflect.Type]bool) error {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return nil
+	}
+	seen[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name, ok := jsonFieldName(f)
+		if !ok {
+			continue
+		}
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+		if tag := f.Tag.Get("validate"); tag != "" {
+			rules, err := parseRules(tag)
+			if err != nil {
+				return fmt.Errorf("field %s: %v", fieldPath, err)
+			}
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			for _, rule := range rules {
+				if !ruleApplies(rule.name, ft.Kind()) {
+					return fmt.Errorf("field %s: validate rule %s can't be used on %s", fieldPath, rule.name, f.Type)
+				}
+			}
+		}
+		if err := checkTypeTags(f.Type, fieldPath, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// regexp的表达式里可以有逗号，所以regexp之后的内容都属于它
+func splitRules(tag string) []string {
+	var rules []string
+	for tag != "" {
+		if strings.HasPrefix(tag, "regexp=") {
+			return append(rules, tag)
+		}
+		i := strings.Index(tag, ",")
+		if i < 0 {
+			return append(rules, strings.TrimSpace(tag))
+		}
+		if rule := strings.TrimSpace(tag[:i]); rule != "" {
+			rules = append(rules, rule)
+		}
+		tag = strings.TrimLeft(tag[i+1:], " ")
+	}
+	return rules
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// 数字返回值，字符串、切片和map返回长度，其他类型ok为false。v无效时只检查类型
+func measure(kind reflect.Kind, v reflect.Value) (size float64, isLen, ok bool) {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.IsValid() {
+			size = float64(v.Int())
+		}
+		return size, false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.IsValid() {
+			size = float64(v.Uint())
+		}
+		return size, false, true
+	case reflect.Float32, reflect.Float64:
+		if v.IsValid() {
+			size = v.Float()
+		}
+		return size, false, true
+	case reflect.String:
+		if v.IsValid() {
+			size = float64(utf8.RuneCountInString(v.String()))
+		}
+		return size, true, true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		if v.IsValid() {
+			size = float64(v.Len())
+		}
+		return size, true, true
+	}
+	return 0, false, false
+}
@@ -0,0 +1,169 @@
+package win
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type validateItem struct {
+	Name string `json:"name" validate:"required,max=5"`
+}
+
+type validateParams struct {
+	Name   string         `json:"name" validate:"required"`
+	Age    int            `json:"age" validate:"min=18,max=60"`
+	Code   string         `json:"code" validate:"len=3"`
+	Tags   []string       `json:"tags" validate:"min=1,max=2"`
+	Role   string         `json:"role" validate:"oneof=admin user"`
+	Phone  string         `json:"phone" validate:"regexp=^[0-9]{3,4}$"`
+	Nick   *string        `json:"nick" validate:"min=2"`
+	Any    interface{}    `json:"any" validate:"max=3"`
+	Items  []validateItem `json:"items"`
+	Inner  *validateItem  `json:"inner"`
+	hidden string         `validate:"required"` // 不导出的字段不校验
+}
+
+func validParams() validateParams {
+	return validateParams{
+		Name:  "tom",
+		Age:   20,
+		Code:  "abc",
+		Tags:  []string{"a"},
+		Role:  "user",
+		Phone: "1234",
+	}
+}
+
+func TestValidate(t *testing.T) {
+	nick := "a"
+	tests := []struct {
+		name   string
+		modify func(p *validateParams)
+		want   []FieldError
+	}{
+		{"valid", func(p *validateParams) {}, nil},
+		{"required", func(p *validateParams) { p.Name = "" }, []FieldError{{"name", "required"}}},
+		{"min", func(p *validateParams) { p.Age = 17 }, []FieldError{{"age", "must be at least 18"}}},
+		{"max", func(p *validateParams) { p.Age = 61 }, []FieldError{{"age", "must be at most 60"}}},
+		{"len", func(p *validateParams) { p.Code = "abcd" }, []FieldError{{"code", "length must be 3"}}},
+		{"len counts runes", func(p *validateParams) { p.Code = "中文字" }, nil},
+		{"slice min", func(p *validateParams) { p.Tags = nil }, []FieldError{{"tags", "length must be at least 1"}}},
+		{"slice max", func(p *validateParams) { p.Tags = []string{"a", "b", "c"} }, []FieldError{{"tags", "length must be at most 2"}}},
+		{"oneof", func(p *validateParams) { p.Role = "root" }, []FieldError{{"role", "must be one of [admin user]"}}},
+		// 表达式中的逗号属于regexp
+		{"regexp", func(p *validateParams) { p.Phone = "12" }, []FieldError{{"phone", "must match ^[0-9]{3,4}$"}}},
+		{"nil pointer skips rules", func(p *validateParams) { p.Nick = nil }, nil},
+		{"pointer", func(p *validateParams) { p.Nick = &nick }, []FieldError{{"nick", "length must be at least 2"}}},
+		{"interface number", func(p *validateParams) { p.Any = 4.0 }, []FieldError{{"any", "must be at most 3"}}},
+		{"interface string", func(p *validateParams) { p.Any = "abcd" }, []FieldError{{"any", "length must be at most 3"}}},
+		{"interface mismatch", func(p *validateParams) { p.Any = true }, []FieldError{{"any", "must be a number, string, array or object"}}},
+		{"nested slice", func(p *validateParams) {
+			p.Items = []validateItem{{"ok"}, {""}, {"toolong"}}
+		}, []FieldError{{"items[1].name", "required"}, {"items[2].name", "length must be at most 5"}}},
+		{"nested pointer", func(p *validateParams) { p.Inner = &validateItem{} }, []FieldError{{"inner.name", "required"}}},
+		{"multiple", func(p *validateParams) { p.Name = ""; p.Age = 0 }, []FieldError{{"name", "required"}, {"age", "must be at least 18"}}},
+	}
+	for _, tt := range tests {
+		p := validParams()
+		tt.modify(&p)
+		err := Validate(&p)
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%s: got %v, want *Error", tt.name, err)
+			continue
+		}
+		if e.Code != CodeInvalidParams || !reflect.DeepEqual(e.Data, tt.want) {
+			t.Errorf("%s: got %d %v, want %v", tt.name, e.Code, e.Data, tt.want)
+		}
+	}
+}
+
+func TestValidateSplitRules(t *testing.T) {
+	got := splitRules("required, min=1,regexp=^a,b{1,2}$")
+	want := []string{"required", "min=1", "regexp=^a,b{1,2}$"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCheckValidateTags(t *testing.T) {
+	type ok struct {
+		A string      `validate:"required,len=2,regexp=^a,b$"`
+		B interface{} `validate:"min=1,regexp=x"`
+		C *int        `validate:"max=3"`
+	}
+	type badNumber struct {
+		A int `validate:"min=x"`
+	}
+	type unknown struct {
+		A int `validate:"email"`
+	}
+	type badRegexp struct {
+		A string `validate:"regexp=("`
+	}
+	type lenOnInt struct {
+		A int `validate:"len=1"`
+	}
+	type regexpOnInt struct {
+		A []struct {
+			B int `json:"b" validate:"regexp=1"`
+		} `json:"a"`
+	}
+	type minOnBool struct {
+		A bool `validate:"min=1"`
+	}
+	tests := []struct {
+		v   interface{}
+		err string
+	}{
+		{ok{}, ""},
+		{&[]ok{}, ""},
+		{badNumber{}, "field A: invalid validate rule min=x"},
+		{unknown{}, "field A: unknown validate rule email"},
+		{badRegexp{}, "field A: invalid validate rule regexp=("},
+		{lenOnInt{}, "field A: validate rule len can't be used on int"},
+		{regexpOnInt{}, "field a.b: validate rule regexp can't be used on int"},
+		{minOnBool{}, "field A: validate rule min can't be used on bool"},
+	}
+	for _, tt := range tests {
+		err := checkValidateTags(reflect.TypeOf(tt.v))
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%T: %v", tt.v, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%T: got %v, want %s", tt.v, err, tt.err)
+		}
+	}
+}
+
+func TestBindStrict(t *testing.T) {
+	params := json.RawMessage(`{"name":"tom","extra":1}`)
+	ctx := Context{Request: &Request{Params: &params}}
+
+	var p validateItem
+	if err := ctx.Bind(&p); err != nil || p.Name != "tom" {
+		t.Errorf("Bind: %v %+v", err, p)
+	}
+	err := ctx.BindStrict(&p)
+	e, ok := err.(*Error)
+	if !ok || e.Code != CodeInvalidParams || !strings.Contains(e.Data.(string), "extra") {
+		t.Errorf("BindStrict: got %v, want unknown field error", err)
+	}
+
+	params = json.RawMessage(`{"name":""}`)
+	err = ctx.BindStrict(&p)
+	if e, ok := err.(*Error); !ok || !reflect.DeepEqual(e.Data, []FieldError{{"name", "required"}}) {
+		t.Errorf("BindStrict: got %v, want required error", err)
+	}
+}
@@ -4,12 +4,22 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 )
 
 var jsonNull = json.RawMessage("null")
 
+// 框架生成的错误响应使用的错误码
+const (
+	CodeInvalidRequest = 400
+	CodeInvalidParams  = 422
+	CodeNotFound       = 404
+	CodeInternalError  = 500
+)
+
 type (
 	HandlerFunc    func(ctx Context)
+	HandlerFuncE   func(ctx Context) error
 	MiddlewareFunc func(h HandlerFunc) HandlerFunc
 )
 
@@ -18,6 +28,17 @@ type Request struct {
 	Params  *json.RawMessage       `json:"params,omitempty"`
 	ID      int64                  `json:"id"`
 	Headers map[string]interface{} `json:"headers"`
+	// JSON-RPC 2.0请求的原始id，字符串或null时ID为0
+	rawID json.RawMessage
+}
+
+// 是否为不需要响应的通知
+func (r *Request) IsNotification() bool {
+	return r.ID == 0 && r.rawID == nil
+}
+
+func (r *Request) wireID() *json.RawMessage {
+	return wireID(r.ID, r.rawID)
 }
 
 func (r Request) MarshalJSON() ([]byte, error) {
@@ -96,6 +117,23 @@ type Response struct {
 	Result  *json.RawMessage       `json:"result,omitempty"`
 	Error   *Error                 `json:"error,omitempty"`
 	Headers map[string]interface{} `json:"headers"`
+	rawID   json.RawMessage
+}
+
+func (r *Response) wireID() *json.RawMessage {
+	return wireID(r.ID, r.rawID)
+}
+
+// 线路上的id，优先使用原始id，没有id时返回nil
+func wireID(id int64, rawID json.RawMessage) *json.RawMessage {
+	if rawID != nil {
+		return &rawID
+	}
+	if id == 0 {
+		return nil
+	}
+	b := json.RawMessage(strconv.FormatInt(id, 10))
+	return &b
 }
 
 func (r Response) MarshalJSON() ([]byte, error) {
@@ -149,6 +187,9 @@ type Error struct {
 	Code    int         `json:"code"`
 	Message string      `json:"msg"`
 	Data    interface{} `json:"data"`
+
+	// 框架生成的错误，JSON-RPC模式下错误码映射为标准错误码，处理函数的错误码原样发送
+	builtin bool
 }
 
 func (e *Error) Error() string {